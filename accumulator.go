@@ -0,0 +1,171 @@
+package mergetyp
+
+import (
+	"math/rand/v2"
+	"runtime"
+	"sync"
+)
+
+// Accumulator merges values into a running total that can be swapped out,
+// such as to periodically flush metrics. An Accumulator is safe for
+// concurrent use.
+type Accumulator[T any] struct {
+	merge func(l, r interface{})
+	reset func(v interface{})
+
+	mu  sync.Mutex
+	cur *T
+}
+
+// NewAccumulator returns an accumulator for T, merging with a function
+// generated by Gen with the given options. Totals start as a value of T reset
+// by GenReset with the same options, such that fields merged with StrategyMin
+// or StrategyMax take the first value added.
+func NewAccumulator[T any](options ...func(*Config) error) (*Accumulator[T], error) {
+	merge, err := Gen(new(T), options...)
+	if err != nil {
+		return nil, err
+	}
+	reset, err := GenReset(new(T), options...)
+	if err != nil {
+		return nil, err
+	}
+	return &Accumulator[T]{
+		merge: merge,
+		reset: reset,
+		cur:   fresh[T](reset),
+	}, nil
+}
+
+// MustNewAccumulator is like NewAccumulator but panics if the accumulator
+// cannot be created.
+func MustNewAccumulator[T any](options ...func(*Config) error) *Accumulator[T] {
+	a, err := NewAccumulator[T](options...)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// fresh returns a new value to accumulate into.
+func fresh[T any](reset func(interface{})) *T {
+	v := new(T)
+	reset(v)
+	return v
+}
+
+// Add merges v into the current totals. As with any merge, slices, maps, and
+// pointers may be moved out of v into the totals, so v must not be used after
+// it is added.
+func (a *Accumulator[T]) Add(v *T) {
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	a.merge(a.cur, v)
+}
+
+// Swap returns the current totals and starts new totals. The returned value
+// is no longer used by the accumulator.
+func (a *Accumulator[T]) Swap() *T {
+	next := fresh[T](a.reset)
+	a.mu.Lock()
+	defer a.mu.Unlock()
+	cur := a.cur
+	a.cur = next
+	return cur
+}
+
+// ShardedAccumulator is like Accumulator, but spreads values across many
+// totals so that concurrent adds rarely contend on the same lock. Totals are
+// folded together when swapped, making swaps more expensive than with an
+// Accumulator. A ShardedAccumulator is safe for concurrent use.
+type ShardedAccumulator[T any] struct {
+	merge  func(l, r interface{})
+	reset  func(v interface{})
+	shards []shard[T]
+}
+
+type shard[T any] struct {
+	mu  sync.Mutex
+	cur *T
+	_   [48]byte // pad to a cache line to avoid false sharing
+}
+
+// NewShardedAccumulator returns a sharded accumulator for T with the given
+// number of shards, merging with a function generated by Gen with the given
+// options. If shards is not positive, GOMAXPROCS shards are used. Totals
+// start as in NewAccumulator.
+func NewShardedAccumulator[T any](shards int, options ...func(*Config) error) (*ShardedAccumulator[T], error) {
+	merge, err := Gen(new(T), options...)
+	if err != nil {
+		return nil, err
+	}
+	reset, err := GenReset(new(T), options...)
+	if err != nil {
+		return nil, err
+	}
+	if shards <= 0 {
+		shards = runtime.GOMAXPROCS(0)
+	}
+	a := &ShardedAccumulator[T]{
+		merge:  merge,
+		reset:  reset,
+		shards: make([]shard[T], shards),
+	}
+	for i := range a.shards {
+		a.shards[i].cur = fresh[T](reset)
+	}
+	return a, nil
+}
+
+// MustNewShardedAccumulator is like NewShardedAccumulator but panics if the
+// accumulator cannot be created.
+func MustNewShardedAccumulator[T any](shards int, options ...func(*Config) error) *ShardedAccumulator[T] {
+	a, err := NewShardedAccumulator[T](shards, options...)
+	if err != nil {
+		panic(err)
+	}
+	return a
+}
+
+// Add merges v into one shard's totals. We start at a random shard and take
+// the first shard that is not locked, waiting on our starting shard only if
+// every shard is locked. As with Accumulator.Add, v must not be used after it
+// is added.
+func (a *ShardedAccumulator[T]) Add(v *T) {
+	n := len(a.shards)
+	start := rand.IntN(n)
+	for i := 0; i < n; i++ {
+		s := &a.shards[(start+i)%n]
+		if s.mu.TryLock() {
+			a.merge(s.cur, v)
+			s.mu.Unlock()
+			return
+		}
+	}
+	s := &a.shards[start]
+	s.mu.Lock()
+	a.merge(s.cur, v)
+	s.mu.Unlock()
+}
+
+// Swap returns the current totals of all shards folded together and starts
+// new totals. Shards are swapped one at a time; values added concurrently
+// with a swap are either in the returned totals or in the new totals.
+func (a *ShardedAccumulator[T]) Swap() *T {
+	var total *T
+	for i := range a.shards {
+		next := fresh[T](a.reset)
+		s := &a.shards[i]
+		s.mu.Lock()
+		cur := s.cur
+		s.cur = next
+		s.mu.Unlock()
+
+		if total == nil {
+			total = cur
+		} else {
+			a.merge(total, cur)
+		}
+	}
+	return total
+}
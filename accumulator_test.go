@@ -0,0 +1,85 @@
+package mergetyp
+
+import (
+	"math"
+	"sync"
+	"testing"
+)
+
+type accumulated struct {
+	Count int64
+	Low   float64
+	Hist  []uint32
+}
+
+// accumulator is what is common between Accumulator and ShardedAccumulator.
+type accumulator[T any] interface {
+	Add(*T)
+	Swap() *T
+}
+
+func TestAccumulator(t *testing.T) {
+	testAccumulator(t, MustNewAccumulator[accumulated](WithStrategy("Low", StrategyMin)))
+}
+
+func TestShardedAccumulator(t *testing.T) {
+	testAccumulator(t, MustNewShardedAccumulator[accumulated](4, WithStrategy("Low", StrategyMin)))
+}
+
+func testAccumulator(t *testing.T, a accumulator[accumulated]) {
+	const goroutines, adds = 8, 1000
+	var wg sync.WaitGroup
+	var total int64
+	var mu sync.Mutex
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < adds; i++ {
+				a.Add(&accumulated{Count: 1, Low: float64(i + 1), Hist: []uint32{1, 2}})
+				if i%100 == 0 {
+					s := a.Swap()
+					mu.Lock()
+					total += s.Count
+					mu.Unlock()
+				}
+			}
+		}()
+	}
+	wg.Wait()
+
+	s := a.Swap()
+	if total += s.Count; total != goroutines*adds {
+		t.Errorf("got total %d != exp %d", total, goroutines*adds)
+	}
+
+	// Fresh totals are reset according to their strategies.
+	s = a.Swap()
+	if s.Count != 0 || s.Low != math.Inf(1) || s.Hist != nil {
+		t.Errorf("fresh totals are not reset: %#v", s)
+	}
+	a.Add(&accumulated{Low: 3})
+	if s = a.Swap(); s.Low != 3 {
+		t.Errorf("got low %v != exp 3", s.Low)
+	}
+}
+
+func BenchmarkAccumulator(b *testing.B) {
+	benchmarkAccumulator(b, MustNewAccumulator[accumulated]())
+}
+
+func BenchmarkShardedAccumulator(b *testing.B) {
+	benchmarkAccumulator(b, MustNewShardedAccumulator[accumulated](0))
+}
+
+func benchmarkAccumulator(b *testing.B, a accumulator[accumulated]) {
+	b.SetParallelism(8)
+	b.RunParallel(func(pb *testing.PB) {
+		for pb.Next() {
+			a.Add(&accumulated{Count: 1})
+		}
+	})
+	if s := a.Swap(); s.Count != int64(b.N) {
+		b.Fatalf("got count %d != exp %d", s.Count, b.N)
+	}
+}
@@ -0,0 +1,400 @@
+package mergetyp
+
+import (
+	"fmt"
+	"math"
+	"reflect"
+	"strconv"
+	"strings"
+	"sync/atomic"
+	"unsafe"
+)
+
+// This file contains the logic to generate atomic merges; see
+// WithAtomicMerges.
+
+// notAtomic is the error for everything that cannot be merged atomically,
+// with each entry being where the value is and why it cannot be merged.
+type notAtomic []string
+
+func (e notAtomic) Error() string {
+	return "unable to merge atomically (skip these or merge without atomics): " + strings.Join(e, ", ")
+}
+
+// genAtomic generates the closure to atomically merge a primitive or pointer,
+// or returns an error if the value cannot be merged atomically. This returns
+// false for everything else, which is merged as usual.
+func (g *generator) genAtomic(v reflect.Value) (mergeF, bool, error) {
+	k := v.Kind()
+	switch k {
+	case reflect.Int:
+		k = reflect.Int32
+		if strconv.IntSize == 64 {
+			k = reflect.Int64
+		}
+	case reflect.Uint:
+		k = reflect.Uint32
+		if strconv.IntSize == 64 {
+			k = reflect.Uint64
+		}
+	}
+
+	switch k {
+	case reflect.Int32:
+		return atomicInt(g.strategy, atomic.AddInt32, atomic.LoadInt32, atomic.CompareAndSwapInt32), true, nil
+	case reflect.Int64:
+		return atomicInt(g.strategy, atomic.AddInt64, atomic.LoadInt64, atomic.CompareAndSwapInt64), true, nil
+	case reflect.Uint32:
+		return atomicInt(g.strategy, atomic.AddUint32, atomic.LoadUint32, atomic.CompareAndSwapUint32), true, nil
+	case reflect.Uint64:
+		return atomicInt(g.strategy, atomic.AddUint64, atomic.LoadUint64, atomic.CompareAndSwapUint64), true, nil
+	case reflect.Uintptr:
+		return atomicInt(g.strategy, atomic.AddUintptr, atomic.LoadUintptr, atomic.CompareAndSwapUintptr), true, nil
+	case reflect.Float32:
+		return atomicFloat(g.strategy, atomic.LoadUint32, atomic.CompareAndSwapUint32, math.Float32frombits, math.Float32bits), true, nil
+	case reflect.Float64:
+		return atomicFloat(g.strategy, atomic.LoadUint64, atomic.CompareAndSwapUint64, math.Float64frombits, math.Float64bits), true, nil
+
+	case reflect.Bool, reflect.Int8, reflect.Int16, reflect.Uint8, reflect.Uint16,
+		reflect.Complex64, reflect.Complex128, reflect.Slice, reflect.Map, reflect.Interface:
+		return nil, true, notAtomic{g.where(v) + " (" + v.Type().String() + ")"}
+
+	case reflect.Ptr:
+		f, err := g.at(g.plan.child("*")).gen(reflect.Zero(v.Type().Elem()))
+		if err != nil || f == nil {
+			return nil, true, err
+		}
+		return atomicPointer(f), true, nil
+	}
+	return nil, false, nil
+}
+
+// atomicPointer returns a closure to atomically merge pointers, merging what
+// they point to with f. As with non-atomic merges, if left is nil, we move
+// right's pointer to left. We only move if left is still nil, otherwise we
+// merge into what was concurrently set.
+func atomicPointer(f mergeF) mergeF {
+	return func(l, r unsafe.Pointer) {
+		pl := (*unsafe.Pointer)(l)
+		pr := (*unsafe.Pointer)(r)
+		ir := atomic.LoadPointer(pr)
+		if ir == nil {
+			return
+		}
+		for {
+			il := atomic.LoadPointer(pl)
+			if il != nil {
+				f(il, ir)
+				return
+			}
+			if atomic.CompareAndSwapPointer(pl, nil, ir) {
+				atomic.StorePointer(pr, nil)
+				return
+			}
+		}
+	}
+}
+
+var (
+	atomicBoolType  = reflect.TypeFor[atomic.Bool]()
+	atomicValueType = reflect.TypeFor[atomic.Value]()
+
+	// atomicInts are the sync/atomic integer types.
+	atomicInts = map[reflect.Type]bool{
+		reflect.TypeFor[atomic.Int32]():   true,
+		reflect.TypeFor[atomic.Int64]():   true,
+		reflect.TypeFor[atomic.Uint32]():  true,
+		reflect.TypeFor[atomic.Uint64]():  true,
+		reflect.TypeFor[atomic.Uintptr](): true,
+	}
+)
+
+// genSyncAtomic generates the closure to merge a type from sync/atomic with
+// its atomic operations, even without WithAtomicMerges. This returns false if
+// the value is not of a sync/atomic type.
+//
+// Every sync/atomic type wraps a single field v, which we merge atomically
+// at its offset.
+func (g *generator) genSyncAtomic(v reflect.Value) (mergeF, bool, error) {
+	t := v.Type()
+	sf, isPointer, ok, err := g.syncAtomic(t, "merge")
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+
+	var f mergeF
+	switch {
+	case isPointer:
+		// atomic.Pointer[T] has a zero length array of *T to
+		// hold its type.
+		g.plan.set(reflect.Ptr, g.strategy)
+		et := t.Field(0).Type.Elem().Elem()
+		ef, err := g.at(g.plan.child("*")).gen(reflect.Zero(et))
+		if err != nil || ef == nil {
+			return nil, true, err
+		}
+		f = atomicPointer(ef)
+
+	case t == atomicBoolType:
+		g.plan.merged(g.strategy)
+		if g.strategy == StrategyMin {
+			f = func(l, r unsafe.Pointer) {
+				if atomic.LoadUint32((*uint32)(r)) == 0 {
+					atomic.StoreUint32((*uint32)(l), 0)
+				}
+			}
+		} else {
+			f = func(l, r unsafe.Pointer) {
+				if atomic.LoadUint32((*uint32)(r)) != 0 {
+					atomic.StoreUint32((*uint32)(l), 1)
+				}
+			}
+		}
+
+	default:
+		g.plan.merged(g.strategy)
+		f, _, _ = g.genAtomic(reflect.Zero(sf.Type))
+	}
+
+	if sf.Offset == 0 {
+		return f, true, nil
+	}
+	return func(l, r unsafe.Pointer) {
+		f(fieldByOffset(l, sf.Offset), fieldByOffset(r, sf.Offset))
+	}, true, nil
+}
+
+// syncAtomic returns the field v of a sync/atomic type and whether the type
+// is atomic.Pointer. This returns false if t is not a sync/atomic type that
+// we support, and an error if t cannot be supported; what is the operation
+// (merge, diff, ...) named in the error.
+func (g *generator) syncAtomic(t reflect.Type, what string) (sf reflect.StructField, isPointer, ok bool, err error) {
+	if !isSyncAtomic(t) {
+		return sf, false, false, nil
+	}
+	if t == atomicValueType {
+		return sf, false, true, fmt.Errorf("unable to %s atomic.Value (unable to determine the type)", what)
+	}
+	sf, ok = t.FieldByName("v")
+	isPointer = strings.HasPrefix(t.Name(), "Pointer[")
+	if !ok || t != atomicBoolType && !atomicInts[t] && !isPointer {
+		return sf, false, false, nil
+	}
+	if len(g.rules) > 0 {
+		return sf, isPointer, true, fmt.Errorf("unable to skip or select fields in %v", t)
+	}
+	return sf, isPointer, true, nil
+}
+
+// isSyncAtomic returns whether t is a type from sync/atomic.
+func isSyncAtomic(t reflect.Type) bool {
+	return t.PkgPath() == "sync/atomic" && t.Kind() == reflect.Struct
+}
+
+// diffSyncAtomic generates the closure to subtract a type from sync/atomic,
+// loading and storing with its atomic operations. This returns false if t is
+// not a sync/atomic type.
+func (g *generator) diffSyncAtomic(t reflect.Type) (diffF, bool, error) {
+	sf, isPointer, ok, err := g.syncAtomic(t, "diff")
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	off := sf.Offset
+
+	var f diffF
+	switch {
+	case isPointer:
+		// We diff the pointer as any other pointer, loading and
+		// storing it atomically.
+		pt := reflect.PointerTo(t.Field(0).Type.Elem().Elem())
+		pf, err := g.diff(reflect.Zero(pt))
+		if err != nil || pf == nil {
+			return nil, true, err
+		}
+		f = func(out, l, r unsafe.Pointer) {
+			po := atomic.LoadPointer((*unsafe.Pointer)(out))
+			pl := atomic.LoadPointer((*unsafe.Pointer)(l))
+			pr := atomic.LoadPointer((*unsafe.Pointer)(r))
+			pf(unsafe.Pointer(&po), unsafe.Pointer(&pl), unsafe.Pointer(&pr))
+			atomic.StorePointer((*unsafe.Pointer)(out), po)
+		}
+	case g.strategy != StrategySum:
+		// Bools and integers merged with other strategies cannot be
+		// undone, and are copied as they are in l.
+		f, err := g.diffCopy(t)
+		return f, true, err
+	case t == atomicBoolType:
+		f = func(out, l, r unsafe.Pointer) {
+			var b uint32
+			if atomic.LoadUint32((*uint32)(l)) != 0 && atomic.LoadUint32((*uint32)(r)) == 0 {
+				b = 1
+			}
+			atomic.StoreUint32((*uint32)(out), b)
+		}
+	case sf.Type.Size() == 4:
+		// Subtracting unsigned integers wraps the same as signed
+		// integers, so every integer is a word of its size.
+		f = func(out, l, r unsafe.Pointer) {
+			atomic.StoreUint32((*uint32)(out), atomic.LoadUint32((*uint32)(l))-atomic.LoadUint32((*uint32)(r)))
+		}
+	default:
+		f = func(out, l, r unsafe.Pointer) {
+			atomic.StoreUint64((*uint64)(out), atomic.LoadUint64((*uint64)(l))-atomic.LoadUint64((*uint64)(r)))
+		}
+	}
+	if off == 0 {
+		return f, true, nil
+	}
+	return func(out, l, r unsafe.Pointer) {
+		f(fieldByOffset(out, off), fieldByOffset(l, off), fieldByOffset(r, off))
+	}, true, nil
+}
+
+// resetSyncAtomic generates the closure to reset a type from sync/atomic,
+// storing with its atomic operations. This returns false if t is not a
+// sync/atomic type.
+func (g *generator) resetSyncAtomic(t reflect.Type) (resetF, bool, bool, error) {
+	sf, isPointer, ok, err := g.syncAtomic(t, "reset")
+	if !ok || err != nil {
+		return nil, false, ok, err
+	}
+	off := sf.Offset
+
+	var f resetF
+	whole := true
+	switch {
+	case isPointer:
+		// What the pointer points to is reset in place, as with any
+		// other pointer.
+		pt := reflect.PointerTo(t.Field(0).Type.Elem().Elem())
+		pf, pwhole, err := g.reset(reflect.Zero(pt))
+		if err != nil || pf == nil {
+			return nil, false, true, err
+		}
+		whole = pwhole
+		f = func(p unsafe.Pointer) {
+			pp := atomic.LoadPointer((*unsafe.Pointer)(p))
+			pf(unsafe.Pointer(&pp))
+		}
+	case t == atomicBoolType:
+		var b uint32
+		if g.strategy == StrategyMin {
+			b = 1
+		}
+		f = func(p unsafe.Pointer) { atomic.StoreUint32((*uint32)(p), b) }
+	default:
+		id, _ := identity(sf.Type, g.strategy)
+		idp := id.Addr().UnsafePointer()
+		if sf.Type.Size() == 4 {
+			bits := *(*uint32)(idp)
+			f = func(p unsafe.Pointer) { atomic.StoreUint32((*uint32)(p), bits) }
+		} else {
+			bits := *(*uint64)(idp)
+			f = func(p unsafe.Pointer) { atomic.StoreUint64((*uint64)(p), bits) }
+		}
+	}
+	if off == 0 {
+		return f, whole, true, nil
+	}
+	return func(p unsafe.Pointer) { f(fieldByOffset(p, off)) }, whole, true, nil
+}
+
+// cloneSyncAtomic generates the closure to deep copy a type from sync/atomic,
+// loading and storing with its atomic operations. This returns false if t is
+// not a sync/atomic type.
+func (g *generator) cloneSyncAtomic(t reflect.Type) (mergeF, bool, error) {
+	sf, isPointer, ok, err := g.syncAtomic(t, "clone")
+	if !ok || err != nil {
+		return nil, ok, err
+	}
+	off := sf.Offset
+
+	var f mergeF
+	switch {
+	case isPointer:
+		pt := reflect.PointerTo(t.Field(0).Type.Elem().Elem())
+		pf, err := g.clone(reflect.Zero(pt))
+		if err != nil || pf == nil {
+			return nil, true, err
+		}
+		f = func(d, s unsafe.Pointer) {
+			ps := atomic.LoadPointer((*unsafe.Pointer)(s))
+			var pd unsafe.Pointer
+			pf(unsafe.Pointer(&pd), unsafe.Pointer(&ps))
+			atomic.StorePointer((*unsafe.Pointer)(d), pd)
+		}
+	case sf.Type.Size() == 4:
+		f = func(d, s unsafe.Pointer) { atomic.StoreUint32((*uint32)(d), atomic.LoadUint32((*uint32)(s))) }
+	default:
+		f = func(d, s unsafe.Pointer) { atomic.StoreUint64((*uint64)(d), atomic.LoadUint64((*uint64)(s))) }
+	}
+	if off == 0 {
+		return f, true, nil
+	}
+	return func(d, s unsafe.Pointer) { f(fieldByOffset(d, off), fieldByOffset(s, off)) }, true, nil
+}
+
+// where returns where we are for error messages: the path to the current
+// field, or the type if we are at the top level.
+func (g *generator) where(v reflect.Value) string {
+	if len(g.path) == 0 {
+		return v.Type().String()
+	}
+	return strings.Join(g.path, ">")
+}
+
+// atomicInt returns a closure to atomically merge integers of type T. Sums
+// are atomic adds, and other strategies are compare-and-swap loops. We load
+// the right value atomically as well, in case it is an atomic type that is
+// concurrently modified.
+func atomicInt[T int32 | int64 | uint32 | uint64 | uintptr](
+	s Strategy,
+	add func(*T, T) T,
+	load func(*T) T,
+	cas func(*T, T, T) bool,
+) mergeF {
+	if s == StrategySum {
+		return func(l, r unsafe.Pointer) { add((*T)(l), load((*T)(r))) }
+	}
+	return func(l, r unsafe.Pointer) {
+		pl := (*T)(l)
+		ir := load((*T)(r))
+		for {
+			il := load(pl)
+			if !replaces(s, il, ir) {
+				return
+			}
+			if cas(pl, il, ir) {
+				return
+			}
+		}
+	}
+}
+
+// atomicFloat returns a closure to atomically merge floats of type F, which
+// are stored as bits of type U, with compare-and-swap loops.
+func atomicFloat[F float32 | float64, U uint32 | uint64](
+	s Strategy,
+	load func(*U) U,
+	cas func(*U, U, U) bool,
+	frombits func(U) F,
+	tobits func(F) U,
+) mergeF {
+	return func(l, r unsafe.Pointer) {
+		pl := (*U)(l)
+		ir := frombits(load((*U)(r)))
+		for {
+			old := load(pl)
+			il := frombits(old)
+			next := ir
+			if s == StrategySum {
+				next = il + ir
+			} else if !replaces(s, il, ir) {
+				return
+			}
+			if cas(pl, old, tobits(next)) {
+				return
+			}
+		}
+	}
+}
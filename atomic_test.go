@@ -0,0 +1,163 @@
+package mergetyp
+
+import (
+	"math"
+	"sync"
+	"sync/atomic"
+	"testing"
+)
+
+type atomicInner struct {
+	N uint32
+}
+
+type atomicType struct {
+	Count int64
+	Sum   float64
+	Max   int
+	Min   float32
+	Inner *atomicInner
+	Arr   [2]uintptr
+	Hist  []int
+	Flag  bool
+}
+
+func TestWithAtomicMerges(t *testing.T) {
+	merge := MustGen(new(atomicType),
+		WithAtomicMerges(),
+		SkipFields("Hist", "Flag"),
+		WithStrategy("Max", StrategyMax),
+		WithStrategy("Min", StrategyMin),
+	)
+
+	const goroutines, merges = 8, 1000
+	shared := atomicType{Min: 1e9}
+	var wg sync.WaitGroup
+	for g := 0; g < goroutines; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < merges; i++ {
+				merge(&shared, &atomicType{
+					Count: 1,
+					Sum:   0.5,
+					Max:   g*merges + i,
+					Min:   float32(g*merges + i),
+					Inner: &atomicInner{1},
+					Arr:   [2]uintptr{1, 2},
+				})
+			}
+		}(g)
+	}
+	wg.Wait()
+
+	const n = goroutines * merges
+	if shared.Count != n || shared.Sum != n/2 || shared.Max != n-1 || shared.Min != 0 ||
+		shared.Inner.N != n || shared.Arr != [2]uintptr{n, 2 * n} {
+		t.Errorf("unexpected merge result %#v, inner %#v", shared, shared.Inner)
+	}
+
+	_, err := Gen(new(atomicType), WithAtomicMerges())
+	if err == nil {
+		t.Fatal("expected error for fields that cannot be merged atomically")
+	}
+	exp := "unable to merge atomically (skip these or merge without atomics): Hist ([]int), Flag (bool)"
+	if err.Error() != exp {
+		t.Errorf("got error %q != exp %q", err, exp)
+	}
+}
+
+type syncAtomicType struct {
+	Count atomic.Int64
+	Small atomic.Int32
+	Max   atomic.Uint64
+	Ptr   atomic.Uintptr
+	Set   atomic.Bool
+	Inner atomic.Pointer[atomicInner]
+	Plain int
+}
+
+func TestSyncAtomicTypes(t *testing.T) {
+	merge := MustGen(new(syncAtomicType), WithStrategy("Max", StrategyMax))
+
+	var l, r syncAtomicType
+	l.Count.Store(1)
+	l.Max.Store(10)
+	r.Count.Store(2)
+	r.Small.Store(3)
+	r.Max.Store(5)
+	r.Ptr.Store(4)
+	r.Set.Store(true)
+	r.Inner.Store(&atomicInner{1})
+	r.Plain = 1
+	merge(&l, &r)
+
+	if l.Count.Load() != 3 || l.Small.Load() != 3 || l.Max.Load() != 10 ||
+		l.Ptr.Load() != 4 || !l.Set.Load() || l.Inner.Load().N != 1 || l.Plain != 1 {
+		t.Fatalf("unexpected merge result")
+	}
+	if r.Inner.Load() != nil {
+		t.Errorf("right pointer was not moved to the left")
+	}
+
+	// Concurrent merges into atomic fields are race free even without
+	// WithAtomicMerges, which we skip the plain field for. What an
+	// atomic.Pointer points to is merged as usual.
+	merge = MustGen(new(syncAtomicType), SkipField("Plain"))
+	var shared syncAtomicType
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			for i := 0; i < 100; i++ {
+				var r syncAtomicType
+				r.Count.Store(1)
+				merge(&shared, &r)
+			}
+		}()
+	}
+	wg.Wait()
+	if shared.Count.Load() != 400 {
+		t.Errorf("got count %d != exp 400", shared.Count.Load())
+	}
+
+	// Diffing, resetting, and cloning use atomic operations as well, and
+	// understand bools.
+	var cur, prev, delta syncAtomicType
+	cur.Count.Store(5)
+	cur.Inner.Store(&atomicInner{3})
+	prev.Count.Store(2)
+	prev.Set.Store(true)
+	prev.Inner.Store(&atomicInner{1})
+	MustGenDiff(new(syncAtomicType), SkipField("Max"))(&delta, &cur, &prev)
+	if delta.Count.Load() != 3 || delta.Set.Load() || delta.Inner.Load().N != 2 {
+		t.Errorf("diff got count %d, set %v, inner %d, exp 3, false, 2",
+			delta.Count.Load(), delta.Set.Load(), delta.Inner.Load().N)
+	}
+
+	var c syncAtomicType
+	MustGenClone(new(syncAtomicType))(&c, &cur)
+	if c.Count.Load() != 5 || c.Inner.Load() == cur.Inner.Load() || c.Inner.Load().N != 3 {
+		t.Errorf("clone got count %d, inner %v, exp 5 and a copy of %v", c.Count.Load(), c.Inner.Load(), cur.Inner.Load())
+	}
+
+	cur.Set.Store(true)
+	MustGenReset(new(syncAtomicType), WithStrategy("Max", StrategyMin))(&cur)
+	if cur.Count.Load() != 0 || cur.Set.Load() || cur.Max.Load() != math.MaxUint64 || cur.Inner.Load().N != 0 {
+		t.Errorf("reset got count %d, set %v, max %d, inner %d", cur.Count.Load(), cur.Set.Load(), cur.Max.Load(), cur.Inner.Load().N)
+	}
+
+	for _, test := range []struct {
+		name string
+		i    interface{}
+		opts []func(*Config) error
+	}{
+		{"value", new(struct{ V atomic.Value }), nil},
+		{"selecting within", new(syncAtomicType), []func(*Config) error{SkipField("Count>v")}},
+	} {
+		if _, err := Gen(test.i, test.opts...); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
@@ -0,0 +1,173 @@
+package mergetyp
+
+import (
+	"fmt"
+	"math/big"
+	"reflect"
+	"unsafe"
+)
+
+// This file contains the logic to merge math/big numbers, which are structs
+// of words that we must not reach into.
+
+// bigNumber is what *big.Int, *big.Float, and *big.Rat have in common.
+type bigNumber[T any] interface {
+	*T
+	Add(x, y *T) *T
+	Sub(x, y *T) *T
+	Set(x *T) *T
+	Cmp(y *T) int
+	Sign() int
+}
+
+var (
+	bigIntType   = reflect.TypeFor[big.Int]()
+	bigFloatType = reflect.TypeFor[big.Float]()
+	bigRatType   = reflect.TypeFor[big.Rat]()
+)
+
+// isBig returns whether t is a big.Int, big.Float, or big.Rat.
+func isBig(t reflect.Type) bool {
+	return t == bigIntType || t == bigFloatType || t == bigRatType
+}
+
+// genBig generates the closure to merge a big number. This returns false if
+// the value is not a big number. Pointers to big numbers are merged as any
+// other pointer, with what they point to merged here.
+func (g *generator) genBig(v reflect.Value) (mergeF, bool, error) {
+	t := v.Type()
+	if !isBig(t) {
+		return nil, false, nil
+	}
+	if len(g.rules) > 0 {
+		return nil, true, fmt.Errorf("unable to skip or select fields in %v", t)
+	}
+	if g.atomic {
+		return nil, true, notAtomic{g.where(v) + " (" + t.String() + ")"}
+	}
+	g.plan.merged(g.strategy)
+
+	switch t {
+	case bigIntType:
+		return mergeBig[big.Int](g.strategy), true, nil
+	case bigFloatType:
+		return mergeBig[big.Float](g.strategy), true, nil
+	default:
+		return mergeBig[big.Rat](g.strategy), true, nil
+	}
+}
+
+// mergeBig returns a closure to merge big numbers of type T with strategy s.
+// As with other numbers, keeping and overwriting never keep a zero number
+// over a non-zero one.
+func mergeBig[T any, P bigNumber[T]](s Strategy) mergeF {
+	switch s {
+	case StrategyMin:
+		return func(l, r unsafe.Pointer) {
+			if P(r).Cmp((*T)(l)) < 0 {
+				P(l).Set((*T)(r))
+			}
+		}
+	case StrategyMax:
+		return func(l, r unsafe.Pointer) {
+			if P(r).Cmp((*T)(l)) > 0 {
+				P(l).Set((*T)(r))
+			}
+		}
+	case StrategyKeep:
+		return func(l, r unsafe.Pointer) {
+			if P(l).Sign() == 0 {
+				P(l).Set((*T)(r))
+			}
+		}
+	case StrategyOverwrite:
+		return func(l, r unsafe.Pointer) {
+			if P(r).Sign() != 0 {
+				P(l).Set((*T)(r))
+			}
+		}
+	default:
+		return func(l, r unsafe.Pointer) { P(l).Add((*T)(l), (*T)(r)) }
+	}
+}
+
+// bigRules returns an error if there are rules within a big number.
+func (g *generator) bigRules(t reflect.Type) error {
+	if len(g.rules) > 0 {
+		return fmt.Errorf("unable to skip or select fields in %v", t)
+	}
+	return nil
+}
+
+// cloneBig generates the closure to deep copy a big number of type t.
+func (g *generator) cloneBig(t reflect.Type) (mergeF, error) {
+	if err := g.bigRules(t); err != nil {
+		return nil, err
+	}
+	switch t {
+	case bigIntType:
+		return cloneBig[big.Int](), nil
+	case bigFloatType:
+		return cloneBig[big.Float](), nil
+	default:
+		return cloneBig[big.Rat](), nil
+	}
+}
+
+// cloneBig returns a closure to deep copy big numbers of type T. The
+// destination is zeroed first so that we never write into words it may share
+// with another number.
+func cloneBig[T any, P bigNumber[T]]() mergeF {
+	return func(d, s unsafe.Pointer) {
+		var z T
+		*(*T)(d) = z
+		P(d).Set((*T)(s))
+	}
+}
+
+// diffBig generates the closure to subtract a big number of type t.
+func (g *generator) diffBig(t reflect.Type) (diffF, error) {
+	if err := g.bigRules(t); err != nil {
+		return nil, err
+	}
+	if g.strategy != StrategySum {
+		return g.diffCopy(t)
+	}
+	switch t {
+	case bigIntType:
+		return diffBig[big.Int](), nil
+	case bigFloatType:
+		return diffBig[big.Float](), nil
+	default:
+		return diffBig[big.Rat](), nil
+	}
+}
+
+// diffBig returns a closure to subtract big numbers of type T.
+func diffBig[T any, P bigNumber[T]]() diffF {
+	return func(out, l, r unsafe.Pointer) {
+		var z T
+		*(*T)(out) = z
+		P(out).Sub((*T)(l), (*T)(r))
+	}
+}
+
+// resetBig generates the closure to reset a big number of type t to what
+// merging with our strategy starts from. Only floats have a largest and
+// smallest value, so integers and rationals merged with StrategyMin or
+// StrategyMax cannot be reset.
+func (g *generator) resetBig(t reflect.Type) (resetF, error) {
+	if err := g.bigRules(t); err != nil {
+		return nil, err
+	}
+	switch g.strategy {
+	case StrategyMin, StrategyMax:
+		if t != bigFloatType {
+			return nil, fmt.Errorf("unable to reset %v merged with strategy %v (there is no identity)", t, g.strategy)
+		}
+		neg := g.strategy == StrategyMax
+		return func(p unsafe.Pointer) { (*big.Float)(p).SetInf(neg) }, nil
+	}
+	size := t.Size()
+	return func(p unsafe.Pointer) { clear(unsafe.Slice((*byte)(p), size)) }, nil
+}
@@ -0,0 +1,90 @@
+package mergetyp
+
+import (
+	"math/big"
+	"testing"
+)
+
+type bigType struct {
+	Cents *big.Int
+	Ratio big.Rat
+	Peak  big.Float
+	Low   *big.Int
+}
+
+func TestBigNumbers(t *testing.T) {
+	opts := []func(*Config) error{
+		WithStrategy("Peak", StrategyMax),
+		WithStrategy("Low", StrategyMin),
+	}
+	f := MustGen(new(bigType), opts...)
+
+	// Values larger than a word would be corrupted if summed word by word.
+	huge, _ := new(big.Int).SetString("18446744073709551615", 10)
+	l := bigType{Cents: new(big.Int).Set(huge), Low: big.NewInt(5)}
+	l.Ratio.SetFrac64(1, 3)
+	l.Peak.SetFloat64(1.5)
+	r := bigType{Cents: big.NewInt(1), Low: big.NewInt(-2)}
+	r.Ratio.SetFrac64(1, 6)
+	r.Peak.SetFloat64(2.5)
+	f(&l, &r)
+
+	expCents, _ := new(big.Int).SetString("18446744073709551616", 10)
+	if l.Cents.Cmp(expCents) != 0 {
+		t.Errorf("cents got %v != exp %v", l.Cents, expCents)
+	}
+	if l.Ratio.Cmp(big.NewRat(1, 2)) != 0 {
+		t.Errorf("ratio got %v != exp 1/2", &l.Ratio)
+	}
+	if l.Peak.Cmp(big.NewFloat(2.5)) != 0 {
+		t.Errorf("peak got %v != exp 2.5", &l.Peak)
+	}
+	if l.Low.Int64() != -2 {
+		t.Errorf("low got %v != exp -2", l.Low)
+	}
+
+	// A nil left pointer takes the right pointer, as with other pointers.
+	var z bigType
+	f(&z, &bigType{Cents: big.NewInt(3)})
+	if z.Cents == nil || z.Cents.Int64() != 3 {
+		t.Errorf("nil left cents got %v != exp 3", z.Cents)
+	}
+
+	var c bigType
+	MustGenClone(new(bigType))(&c, &l)
+	c.Cents.Add(c.Cents, big.NewInt(1))
+	if l.Cents.Cmp(expCents) != 0 || c.Cents.Cmp(expCents) <= 0 {
+		t.Errorf("clone shares memory: original %v, clone %v", l.Cents, c.Cents)
+	}
+
+	var d bigType
+	MustGenDiff(new(bigType), SkipField("Peak"), SkipField("Low"))(&d, &l, &r)
+	if d.Cents.Cmp(huge) != 0 || d.Ratio.Cmp(big.NewRat(1, 3)) != 0 {
+		t.Errorf("diff got %v and %v, exp %v and 1/3", d.Cents, &d.Ratio, huge)
+	}
+
+	// Numbers merged with other strategies are copied from the left.
+	d = bigType{}
+	MustGenDiff(new(bigType), opts...)(&d, &l, &r)
+	if d.Peak.Cmp(&l.Peak) != 0 || d.Low.Cmp(l.Low) != 0 || d.Low == l.Low {
+		t.Errorf("diff max got %v and %v, exp %v and a copy of %v", &d.Peak, d.Low, &l.Peak, l.Low)
+	}
+
+	MustGenReset(new(bigType), SkipField("Low"), opts[0])(&c)
+	if c.Cents.Sign() != 0 || c.Ratio.Sign() != 0 || !c.Peak.IsInf() || c.Peak.Sign() > 0 {
+		t.Errorf("reset got %v, %v, %v", c.Cents, &c.Ratio, &c.Peak)
+	}
+
+	for _, test := range []struct {
+		name string
+		gen  func() error
+	}{
+		{"reset min int", func() error { _, err := GenReset(new(bigType), opts...); return err }},
+		{"atomic", func() error { _, err := Gen(new(bigType), WithAtomicMerges()); return err }},
+		{"select", func() error { _, err := Gen(new(bigType), SkipField("Ratio>a")); return err }},
+	} {
+		if err := test.gen(); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
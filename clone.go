@@ -0,0 +1,465 @@
+package mergetyp
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+// This file contains the logic to recursively generate clone functions. Clone
+// functions take a destination and a source pointer, the same as merge
+// functions take a left and right pointer, so we reuse the mergeF type.
+//
+// Values that contain no pointers are copied byte for byte; everything else
+// is copied field by field, element by element, allocating as we go.
+
+// sliceHeader is the layout of a slice. Unlike reflect.SliceHeader, the data
+// is a pointer, so that writing a header is seen by the garbage collector.
+type sliceHeader struct {
+	data unsafe.Pointer
+	len  int
+	cap  int
+}
+
+// GenClone returns a function to deep copy a value into another value of the
+// same type.
+//
+// The returned function copies the src value into the dst value. Pointers,
+// slices, and maps are copied into new allocations, such that nothing in dst
+// references memory in src afterwards. Strings are immutable and are shared,
+// as are channels and functions, which cannot be copied. Interfaces and
+// unsafe pointers cannot be cloned. Cyclic values (a pointer that eventually
+// points to itself) cannot be cloned and loop forever.
+//
+// GenClone takes the same options as Gen. Fields that would be skipped when
+// merging are not copied: they are left as they are in dst, and are zero in
+// anything newly allocated. Map keys that would be skipped are not copied,
+// and as with Gen, maps can only be cloned with WithSlowerMapsUnsafely.
+// Strategies have no effect on cloning.
+//
+// The input type must be a singly-indirected value, and the returned function
+// panics if used on other types, the same as with Gen.
+func GenClone(i interface{}, options ...func(*Config) error) (func(dst, src interface{}), error) {
+	g, v, err := newGenerator(i, options)
+	if err != nil {
+		return nil, err
+	}
+	f, err := g.clone(v)
+	if err != nil {
+		return nil, err
+	}
+
+	check := typeChecker(i, "clone")
+	if f == nil { // everything is skipped
+		return func(dst, src interface{}) {
+			check(dst)
+			check(src)
+		}, nil
+	}
+	return func(dst, src interface{}) {
+		dp, sp := check(dst), check(src)
+		f(dp, sp)
+	}, nil
+}
+
+// MustGenClone is like GenClone but panics if the clone function cannot be
+// generated.
+func MustGenClone(i interface{}, options ...func(*Config) error) func(dst, src interface{}) {
+	f, err := GenClone(i, options...)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// hasPointers returns whether a value of type t contains any pointers, that
+// is, whether t cannot be copied byte for byte.
+func hasPointers(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr,
+		reflect.Float32, reflect.Float64, reflect.Complex64, reflect.Complex128:
+		return false
+	case reflect.Array:
+		return t.Len() > 0 && hasPointers(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasPointers(t.Field(i).Type) {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// hasStructs returns whether a value of type t contains any structs.
+func hasStructs(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Array:
+		return hasStructs(t.Elem())
+	case reflect.Struct:
+		return true
+	default:
+		return false
+	}
+}
+
+// bytewise returns whether a value of type t can be cloned byte for byte: it
+// has no pointers, nothing within it can be skipped, including locks, nothing
+// within it has a registered merger, and nothing within it must be accessed
+// atomically.
+func (g *generator) bytewise(t reflect.Type) bool {
+	return len(g.rules) == 0 && !hasPointers(t) && (len(g.filters) == 0 || !hasStructs(t)) &&
+		!contains(t, func(t reflect.Type) bool {
+			_, merger := g.mergers[t]
+			_, resetter := g.resetters[t]
+			return merger || resetter || isSyncAtomic(t) || g.locking && (t == mutexType || t == rwMutexType)
+		})
+}
+
+// contains returns whether t, or a type within an array or struct t, is a
+// type that f returns true for.
+func contains(t reflect.Type, f func(reflect.Type) bool) bool {
+	if f(t) {
+		return true
+	}
+	switch t.Kind() {
+	case reflect.Array:
+		return t.Len() > 0 && contains(t.Elem(), f)
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if contains(t.Field(i).Type, f) {
+				return true
+			}
+		}
+	}
+	return false
+}
+
+// memmove copies size bytes from s to d, which must not contain pointers.
+func memmove(d, s unsafe.Pointer, size uintptr) {
+	copy(unsafe.Slice((*byte)(d), size), unsafe.Slice((*byte)(s), size))
+}
+
+// clone generates a closure to deep copy an arbitrary value. This returns nil
+// if the value is skipped.
+func (g *generator) clone(v reflect.Value) (mergeF, error) {
+	if g.skip != "" {
+		return nil, nil
+	}
+	t := v.Type()
+	if _, ok := g.mergers[t]; ok {
+		return nil, fmt.Errorf("unable to clone %v, which has a registered merger", t)
+	}
+	if f, ok, err := g.cloneSyncAtomic(t); ok {
+		return f, err
+	}
+	if f, ok, err := g.cloneTree(t); ok {
+		return f, err
+	}
+	if isBig(t) {
+		return g.cloneBig(t)
+	}
+
+	if len(g.rules) > 0 {
+		switch t.Kind() {
+		case reflect.Slice, reflect.Struct, reflect.Array, reflect.Ptr, reflect.Map:
+		default:
+			return nil, fmt.Errorf("unable to skip or select fields on kind %v", t.Kind())
+		}
+	} else if g.bytewise(t) {
+		size := t.Size()
+		return func(d, s unsafe.Pointer) { memmove(d, s, size) }, nil
+	} else if t == timeType {
+		// Times share their immutable location.
+		return func(d, s unsafe.Pointer) { *(*time.Time)(d) = *(*time.Time)(s) }, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		if g.interfaces {
+			return g.cloneInterface(t)
+		}
+		return nil, errors.New("it is impossible to clone interfaces (unable to determine concrete type)")
+	case reflect.UnsafePointer:
+		return nil, errors.New("unable to clone unsafe pointers (unable to determine the type)")
+
+	case reflect.String:
+		return func(d, s unsafe.Pointer) { *(*string)(d) = *(*string)(s) }, nil
+	case reflect.Chan, reflect.Func:
+		return func(d, s unsafe.Pointer) { *(*unsafe.Pointer)(d) = *(*unsafe.Pointer)(s) }, nil
+
+	case reflect.Ptr:
+		et := t.Elem()
+		f, err := g.clone(reflect.Zero(et))
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			return nil, nil
+		}
+		return func(d, s unsafe.Pointer) {
+			ps := *(*unsafe.Pointer)(s)
+			if ps == nil {
+				*(*unsafe.Pointer)(d) = nil
+				return
+			}
+			pd := reflect.New(et).UnsafePointer()
+			f(pd, ps)
+			*(*unsafe.Pointer)(d) = pd
+		}, nil
+
+	case reflect.Array:
+		return g.cloneArray(v)
+
+	case reflect.Slice:
+		return g.cloneSlice(v)
+
+	case reflect.Struct:
+		if len(g.rules) > 0 {
+			return g.cloneStruct(v)
+		}
+
+		// Recursive structs are handled the same as when merging.
+		// Strategies have no effect on cloning, so we cache by type.
+		pf, exists := g.cloneFs[t]
+		if exists {
+			return func(d, s unsafe.Pointer) { (*pf)(d, s) }, nil
+		}
+		pf = new(mergeF)
+		g.cloneFs[t] = pf
+		if len(g.filters) > 0 {
+			defer delete(g.cloneFs, t)
+		}
+		f, err := g.cloneStruct(v)
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			delete(g.cloneFs, t)
+		}
+		*pf = f
+		return f, nil
+
+	case reflect.Map:
+		if !g.useMap {
+			return nil, errors.New("unable to clone maps: use WithSlowerMapsUnsafely if it is absolutely necessary to clone maps")
+		}
+		return g.cloneMap(v)
+
+	default:
+		panic("this switch statement should be comprehensive?")
+	}
+}
+
+// cloneElems generates the closure to clone n elements of type et, starting
+// at the given pointers. Elements selected by index use their own generator.
+func cloneElems(et reflect.Type, def *generator, specials map[int]*generator) (func(d, s unsafe.Pointer, n int), error) {
+	size := et.Size()
+	if specials == nil && def.skip == "" && def.bytewise(et) {
+		return func(d, s unsafe.Pointer, n int) { memmove(d, s, uintptr(n)*size) }, nil
+	}
+
+	z := reflect.Zero(et)
+	f, err := def.clone(z)
+	if err != nil {
+		return nil, err
+	}
+	sfs := make(map[int]mergeF, len(specials))
+	for index, c := range specials {
+		sf, err := c.clone(z)
+		if err != nil {
+			return nil, err
+		}
+		sfs[index] = sf
+	}
+	return func(d, s unsafe.Pointer, n int) {
+		for i := 0; i < n; i++ {
+			ef := f
+			if sf, exists := sfs[i]; exists {
+				ef = sf
+			}
+			if ef != nil {
+				offset := uintptr(i) * size
+				ef(fieldByOffset(d, offset), fieldByOffset(s, offset))
+			}
+		}
+	}, nil
+}
+
+// cloneArray generates the closure to clone an array.
+func (g *generator) cloneArray(v reflect.Value) (mergeF, error) {
+	n := v.Len()
+	def, specials, err := g.splitIndices(n)
+	if err != nil {
+		return nil, err
+	}
+	f, err := cloneElems(v.Type().Elem(), def, specials)
+	if err != nil {
+		return nil, err
+	}
+	return func(d, s unsafe.Pointer) { f(d, s, n) }, nil
+}
+
+// cloneSlice generates the closure to clone a slice. The new slice's capacity
+// is its length.
+func (g *generator) cloneSlice(v reflect.Value) (mergeF, error) {
+	t := v.Type()
+	def, specials, err := g.splitIndices(-1)
+	if err != nil {
+		return nil, err
+	}
+	f, err := cloneElems(t.Elem(), def, specials)
+	if err != nil {
+		return nil, err
+	}
+	return func(d, s unsafe.Pointer) {
+		hs := (*sliceHeader)(s)
+		if hs.data == nil {
+			*(*sliceHeader)(d) = sliceHeader{}
+			return
+		}
+		data := reflect.MakeSlice(t, hs.len, hs.len).UnsafePointer()
+		f(data, hs.data, hs.len)
+		*(*sliceHeader)(d) = sliceHeader{data, hs.len, hs.len}
+	}, nil
+}
+
+// cloneMap generates the closure to clone a map.
+func (g *generator) cloneMap(v reflect.Value) (mergeF, error) {
+	t := v.Type()
+	et := t.Elem()
+
+	def, specials, err := g.splitKeys(t.Key())
+	if err != nil {
+		return nil, err
+	}
+	ez := reflect.Zero(et)
+	f, err := def.clone(ez)
+	if err != nil {
+		return nil, err
+	}
+	// Values whose keys are skipped are not copied at all; we track
+	// which keys are copied separately from their functions, which are
+	// nil if there is nothing to copy within the value.
+	type keyF struct {
+		keep bool
+		f    mergeF
+	}
+	var kfs map[interface{}]keyF
+	if specials != nil {
+		kfs = make(map[interface{}]keyF, len(specials))
+		for k, c := range specials {
+			kf, err := c.clone(ez)
+			if err != nil {
+				return nil, err
+			}
+			kfs[k] = keyF{c.skip == "", kf}
+		}
+	}
+	keep := def.skip == ""
+
+	// Values that can be cloned byte for byte can be copied as is.
+	direct := def.bytewise(et)
+
+	return func(d, s unsafe.Pointer) {
+		ms := reflect.NewAt(t, s).Elem()
+		if ms.IsNil() {
+			*(*unsafe.Pointer)(d) = nil
+			return
+		}
+		md := reflect.MakeMapWithSize(t, ms.Len())
+		iter := ms.MapRange()
+		for iter.Next() {
+			k := iter.Key()
+			keep, f, direct := keep, f, direct
+			if kfs != nil {
+				if kf, exists := kfs[k.Interface()]; exists {
+					keep, f, direct = kf.keep, kf.f, false
+				}
+			}
+			if !keep {
+				continue
+			}
+			if direct {
+				md.SetMapIndex(k, iter.Value())
+				continue
+			}
+			nv := reflect.New(et)
+			if f != nil {
+				sv := reflect.New(et)
+				sv.Elem().Set(iter.Value())
+				f(nv.UnsafePointer(), sv.UnsafePointer())
+			}
+			md.SetMapIndex(k, nv.Elem())
+		}
+		reflect.NewAt(t, d).Elem().Set(md)
+	}, nil
+}
+
+// cloneStruct generates a closure to clone a struct.
+func (g *generator) cloneStruct(v reflect.Value) (mergeF, error) {
+	t := v.Type()
+	field, check, err := g.fields(t)
+	if err != nil {
+		return nil, err
+	}
+
+	// Runs of fields without pointers are copied with one memmove; we
+	// extend the previous run if a field immediately follows it.
+	type span struct {
+		offset uintptr
+		size   uintptr
+	}
+	var spans []span
+	type offsetF struct {
+		offset uintptr
+		f      mergeF
+	}
+	var offsetFs []offsetF
+
+	for i := 0; i < t.NumField(); i++ {
+		sf := t.Field(i)
+		c := field(i)
+		if c.skip != "" {
+			continue
+		}
+		if c.bytewise(sf.Type) {
+			if n := len(spans); n > 0 && spans[n-1].offset+spans[n-1].size == sf.Offset {
+				spans[n-1].size += sf.Type.Size()
+			} else {
+				spans = append(spans, span{sf.Offset, sf.Type.Size()})
+			}
+			continue
+		}
+		f, err := c.clone(v.Field(i))
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			offsetFs = append(offsetFs, offsetF{sf.Offset, f})
+		}
+	}
+
+	if err := check(); err != nil {
+		return nil, err
+	}
+
+	if len(spans) == 0 && len(offsetFs) == 0 {
+		return nil, nil
+	}
+
+	return func(d, s unsafe.Pointer) {
+		for _, sp := range spans {
+			memmove(fieldByOffset(d, sp.offset), fieldByOffset(s, sp.offset), sp.size)
+		}
+		for _, of := range offsetFs {
+			of.f(fieldByOffset(d, of.offset), fieldByOffset(s, of.offset))
+		}
+	}, nil
+}
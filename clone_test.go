@@ -0,0 +1,138 @@
+package mergetyp
+
+import (
+	"reflect"
+	"testing"
+)
+
+type cloneInner struct {
+	N    int
+	Name string
+	P    *int
+}
+
+type cloneType struct {
+	Count  int64
+	Flags  [2]bool
+	Inner  cloneInner
+	Ptr    *cloneInner
+	Elems  []cloneInner
+	Bytes  []byte
+	Labels map[string][]int
+	Ch     chan int
+	Skip   []int
+	List   *recursive
+}
+
+func TestGenClone(t *testing.T) {
+	one, two := 1, 2
+	ch := make(chan int)
+	src := cloneType{
+		Count:  3,
+		Flags:  [2]bool{true, false},
+		Inner:  cloneInner{4, "inner", &one},
+		Ptr:    &cloneInner{5, "ptr", &two},
+		Elems:  []cloneInner{{6, "a", &one}, {7, "b", nil}},
+		Bytes:  []byte("bytes"),
+		Labels: map[string][]int{"a": {1}, "b": {2}, "skip": {3}},
+		Ch:     ch,
+		Skip:   []int{8},
+		List:   &recursive{1, &recursive{2, nil}},
+	}
+	dst := cloneType{Skip: []int{9}}
+
+	clone := MustGenClone(new(cloneType),
+		WithSlowerMapsUnsafely(),
+		SkipFields("Skip", `Labels{"skip"}`, "Elems[1]>Name"),
+	)
+	clone(&dst, &src)
+
+	exp := src
+	exp.Skip = []int{9}
+	exp.Elems = []cloneInner{{6, "a", &one}, {7, "", nil}}
+	exp.Labels = map[string][]int{"a": {1}, "b": {2}}
+	if !reflect.DeepEqual(dst, exp) {
+		t.Fatalf("got %#v != exp %#v", dst, exp)
+	}
+
+	// Nothing in dst may alias src, other than what cannot be copied.
+	if dst.Inner.P == src.Inner.P ||
+		dst.Ptr == src.Ptr ||
+		dst.Ptr.P == src.Ptr.P ||
+		&dst.Elems[0] == &src.Elems[0] ||
+		dst.Elems[0].P == src.Elems[0].P ||
+		&dst.Bytes[0] == &src.Bytes[0] ||
+		&dst.Labels["a"][0] == &src.Labels["a"][0] ||
+		dst.List == src.List ||
+		dst.List.next == src.List.next {
+		t.Error("clone aliases the source")
+	}
+	if dst.Ch != ch {
+		t.Error("channel not shared")
+	}
+
+	// Nil values are cloned as nil, overwriting dst.
+	clone(&dst, new(cloneType))
+	exp = cloneType{Skip: []int{9}}
+	if !reflect.DeepEqual(dst, exp) {
+		t.Errorf("got %#v != exp %#v", dst, exp)
+	}
+
+	for _, test := range []struct {
+		name string
+		i    interface{}
+		opts []func(*Config) error
+	}{
+		{"interface", new(struct{ I interface{} }), nil},
+		{"map without option", new(map[int]int), nil},
+		{"unknown field", new(cloneType), []func(*Config) error{SkipField("Missing")}},
+	} {
+		if _, err := GenClone(test.i, test.opts...); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
+
+func BenchmarkGenClone(b *testing.B) {
+	one := 1
+	src := cloneType{
+		Count: 3,
+		Inner: cloneInner{4, "inner", &one},
+		Ptr:   &cloneInner{5, "ptr", &one},
+		Elems: []cloneInner{{6, "a", &one}, {7, "b", nil}},
+		Bytes: []byte("bytes"),
+		List:  &recursive{1, &recursive{2, nil}},
+	}
+	clone := MustGenClone(new(cloneType), WithSlowerMapsUnsafely())
+	var dst cloneType
+	b.ReportAllocs()
+	for i := 0; i < b.N; i++ {
+		clone(&dst, &src)
+	}
+}
+
+// anonymous has fields of different anonymous struct types, which must not
+// share functions.
+type anonymous struct {
+	A struct {
+		X int64
+		P []int64
+	}
+	B struct {
+		Y float64
+		Q []int64
+		Z int64
+	}
+}
+
+func TestGenCloneAnonymous(t *testing.T) {
+	var src anonymous
+	src.A.X, src.A.P = 1, []int64{2}
+	src.B.Y, src.B.Q, src.B.Z = 3, []int64{4}, 5
+
+	var dst anonymous
+	MustGenClone(new(anonymous))(&dst, &src)
+	if !reflect.DeepEqual(dst, src) {
+		t.Errorf("got %+v != exp %+v", dst, src)
+	}
+}
@@ -0,0 +1,459 @@
+package mergetyp
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// This file contains the logic to recursively generate diff functions, which
+// undo summing merges. Where a merge function adds the right value into the
+// left, a diff function subtracts the right value from the left into an
+// output value.
+
+type diffF = func(out, l, r unsafe.Pointer)
+
+// GenDiff returns a function that computes the difference of two values of
+// the same type, such that merging the difference with the right value
+// results in the left value. This is useful to compute deltas of counters:
+//
+//     diff(delta, current, previous)
+//     merge(delta, previous) // delta now equals current
+//
+// The returned function writes the difference of l minus r into out. Exactly
+// what would be summed by Gen with the same options is subtracted: fields
+// that would be skipped are left as they are in out, and options that cause
+// Gen to fail cause GenDiff to fail. Numbers are subtracted, and bools are
+// true if they are true in l but not in r. Only sums can be undone: numbers
+// and bools merged with other strategies, as well as times, are copied from l
+// into out, such that the difference carries their latest values (a gauge
+// merged with StrategyMax is its current value, not its change).
+//
+// Only differences that can be merged back can be computed: values that exist
+// in r but not in l are not in the difference. Specifically,
+//
+//   - if l's pointer is nil, out's is nil; otherwise, a nil pointer in r is
+//     subtracted as if it pointed to a zero value;
+//   - out's slice has the length of l's slice, and elements past the end of
+//     r are subtracted as if they were zero values;
+//   - out's map has the keys of l's map, and keys not in r are subtracted as
+//     if they were zero values; skipped keys are left as they are in out.
+//
+// Slices, maps, and pointees are allocated in out as necessary, and are reused
+// if out already has them. Nothing in out ever references memory in l or r,
+// other than the immutable locations of times.
+//
+// The input type must be a singly-indirected value, and the returned function
+// panics if used on other types, the same as with Gen.
+func GenDiff(i interface{}, options ...func(*Config) error) (func(out, l, r interface{}), error) {
+	g, v, err := newGenerator(i, options)
+	if err != nil {
+		return nil, err
+	}
+	g.diffFs = make(map[structKey]*diffF)
+	f, err := g.diff(v)
+	if err != nil {
+		return nil, err
+	}
+
+	check := typeChecker(i, "diff")
+	if f == nil { // nothing inside to diff
+		return func(out, l, r interface{}) {
+			check(out)
+			check(l)
+			check(r)
+		}, nil
+	}
+	return func(out, l, r interface{}) {
+		op, lp, rp := check(out), check(l), check(r)
+		f(op, lp, rp)
+	}, nil
+}
+
+// MustGenDiff is like GenDiff but panics if the diff function cannot be
+// generated.
+func MustGenDiff(i interface{}, options ...func(*Config) error) func(out, l, r interface{}) {
+	f, err := GenDiff(i, options...)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// number is every type that merges by summing.
+type number interface {
+	~int | ~int8 | ~int16 | ~int32 | ~int64 |
+		~uint | ~uint8 | ~uint16 | ~uint32 | ~uint64 | ~uintptr |
+		~float32 | ~float64 | ~complex64 | ~complex128
+}
+
+// diffNumber returns a closure to subtract numbers.
+func diffNumber[T number]() diffF {
+	return func(out, l, r unsafe.Pointer) { *(*T)(out) = *(*T)(l) - *(*T)(r) }
+}
+
+// diff generates a closure to subtract an arbitrary value. This returns nil if
+// there is nothing to subtract.
+func (g *generator) diff(v reflect.Value) (diffF, error) {
+	if g.skip != "" {
+		return nil, nil
+	}
+	t := v.Type()
+	if _, ok := g.mergers[t]; ok {
+		return nil, fmt.Errorf("unable to diff %v, which has a registered merger", t)
+	}
+	if f, ok, err := g.diffSyncAtomic(t); ok {
+		return f, err
+	}
+
+	if len(g.rules) > 0 {
+		switch t.Kind() {
+		case reflect.Slice, reflect.Struct, reflect.Array, reflect.Ptr, reflect.Map:
+		default:
+			return nil, fmt.Errorf("unable to skip or select fields on kind %v", t.Kind())
+		}
+	}
+	if g.strategy != StrategySum {
+		_, ok, err := genStrategy(t.Kind(), g.strategy)
+		if err != nil {
+			return nil, err
+		}
+		if ok {
+			return g.diffCopy(t)
+		}
+	}
+
+	if t == timeType {
+		return g.diffCopy(t)
+	}
+	if isBig(t) {
+		return g.diffBig(t)
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		return nil, errors.New("it is impossible to diff two types that are interfaces (unable to determine concrete type)")
+	case reflect.Chan:
+		return nil, errors.New("unable to diff channels")
+	case reflect.Func:
+		return nil, errors.New("unable to diff functions")
+	case reflect.String:
+		return nil, errors.New("unable to diff strings")
+	case reflect.UnsafePointer:
+		return nil, errors.New("unable to diff unsafe pointers (unable to determine the type)")
+	case reflect.Invalid:
+		return nil, errors.New("unable to diff an invalid type")
+
+	case reflect.Bool:
+		return func(out, l, r unsafe.Pointer) { *(*bool)(out) = *(*bool)(l) && !*(*bool)(r) }, nil
+	case reflect.Int:
+		return diffNumber[int](), nil
+	case reflect.Int8:
+		return diffNumber[int8](), nil
+	case reflect.Int16:
+		return diffNumber[int16](), nil
+	case reflect.Int32:
+		return diffNumber[int32](), nil
+	case reflect.Int64:
+		return diffNumber[int64](), nil
+	case reflect.Uint:
+		return diffNumber[uint](), nil
+	case reflect.Uint8:
+		return diffNumber[uint8](), nil
+	case reflect.Uint16:
+		return diffNumber[uint16](), nil
+	case reflect.Uint32:
+		return diffNumber[uint32](), nil
+	case reflect.Uint64:
+		return diffNumber[uint64](), nil
+	case reflect.Uintptr:
+		return diffNumber[uintptr](), nil
+	case reflect.Float32:
+		return diffNumber[float32](), nil
+	case reflect.Float64:
+		return diffNumber[float64](), nil
+	case reflect.Complex64:
+		return diffNumber[complex64](), nil
+	case reflect.Complex128:
+		return diffNumber[complex128](), nil
+
+	case reflect.Ptr:
+		et := t.Elem()
+		f, err := g.diff(reflect.Zero(et))
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			return nil, nil
+		}
+		zero := reflect.New(et).UnsafePointer()
+		return func(out, l, r unsafe.Pointer) {
+			po := (*unsafe.Pointer)(out)
+			pl := *(*unsafe.Pointer)(l)
+			pr := *(*unsafe.Pointer)(r)
+			if pl == nil {
+				*po = nil
+				return
+			}
+			if pr == nil {
+				pr = zero
+			}
+			if *po == nil {
+				*po = reflect.New(et).UnsafePointer()
+			}
+			f(*po, pl, pr)
+		}, nil
+
+	case reflect.Array:
+		n := v.Len()
+		def, specials, err := g.splitIndices(n)
+		if err != nil {
+			return nil, err
+		}
+		f, err := diffElems(t.Elem(), def, specials)
+		if err != nil || f == nil {
+			return nil, err
+		}
+		return func(out, l, r unsafe.Pointer) { f(out, l, r, n, n) }, nil
+
+	case reflect.Slice:
+		return g.diffSlice(v)
+
+	case reflect.Struct:
+		if len(g.rules) > 0 {
+			return g.diffStruct(v)
+		}
+
+		// Recursive structs are handled the same as when merging.
+		key := structKey{t, g.strategy}
+		pf, exists := g.diffFs[key]
+		if exists {
+			return func(out, l, r unsafe.Pointer) { (*pf)(out, l, r) }, nil
+		}
+		pf = new(diffF)
+		g.diffFs[key] = pf
+		if len(g.filters) > 0 {
+			defer delete(g.diffFs, key)
+		}
+		f, err := g.diffStruct(v)
+		if err != nil {
+			return nil, err
+		}
+		if f == nil {
+			delete(g.diffFs, key)
+		}
+		*pf = f
+		return f, nil
+
+	case reflect.Map:
+		if !g.useMap {
+			return nil, errors.New("unable to diff maps: use WithSlowerMapsUnsafely if it is absolutely necessary to diff maps")
+		}
+		return g.diffMap(v)
+
+	default:
+		panic("this switch statement should be comprehensive?")
+	}
+}
+
+// diffCopy generates the closure to copy a value that cannot be subtracted
+// from l into out.
+func (g *generator) diffCopy(t reflect.Type) (diffF, error) {
+	f, err := g.clone(reflect.Zero(t))
+	if err != nil || f == nil {
+		return nil, err
+	}
+	return func(out, l, _ unsafe.Pointer) { f(out, l) }, nil
+}
+
+// diffElems generates the closure to subtract the elements of arrays or
+// slices of type et, where l has ln elements and r has rn. Elements of l past
+// the end of r are subtracted as if r's were zero values. This returns nil if
+// there is nothing to subtract.
+func diffElems(et reflect.Type, def *generator, specials map[int]*generator) (func(out, l, r unsafe.Pointer, ln, rn int), error) {
+	z := reflect.Zero(et)
+	f, err := def.diff(z)
+	if err != nil {
+		return nil, err
+	}
+	sfs := make(map[int]diffF, len(specials))
+	for index, c := range specials {
+		sf, err := c.diff(z)
+		if err != nil {
+			return nil, err
+		}
+		if sf != nil {
+			sfs[index] = sf
+		}
+	}
+	if f == nil && len(sfs) == 0 {
+		return nil, nil
+	}
+
+	size := et.Size()
+	zero := reflect.New(et).UnsafePointer()
+	return func(out, l, r unsafe.Pointer, ln, rn int) {
+		for i := 0; i < ln; i++ {
+			ef := f
+			if sf, exists := sfs[i]; exists {
+				ef = sf
+			} else if _, exists := specials[i]; exists {
+				continue // skipped
+			}
+			if ef == nil {
+				continue
+			}
+			offset := uintptr(i) * size
+			re := zero
+			if i < rn {
+				re = fieldByOffset(r, offset)
+			}
+			ef(fieldByOffset(out, offset), fieldByOffset(l, offset), re)
+		}
+	}, nil
+}
+
+// diffSlice generates the closure to subtract a slice.
+func (g *generator) diffSlice(v reflect.Value) (diffF, error) {
+	t := v.Type()
+	def, specials, err := g.splitIndices(-1)
+	if err != nil {
+		return nil, err
+	}
+	f, err := diffElems(t.Elem(), def, specials)
+	if err != nil || f == nil {
+		return nil, err
+	}
+	return func(out, l, r unsafe.Pointer) {
+		ho := (*sliceHeader)(out)
+		hl := (*sliceHeader)(l)
+		hr := (*sliceHeader)(r)
+		if hl.data == nil {
+			*ho = sliceHeader{}
+			return
+		}
+		if ho.data == nil || ho.cap < hl.len {
+			*ho = sliceHeader{reflect.MakeSlice(t, hl.len, hl.len).UnsafePointer(), hl.len, hl.len}
+		}
+		ho.len = hl.len
+		f(ho.data, hl.data, hr.data, hl.len, hr.len)
+	}, nil
+}
+
+// diffMap generates the closure to subtract a map.
+func (g *generator) diffMap(v reflect.Value) (diffF, error) {
+	t := v.Type()
+	et := t.Elem()
+
+	def, specials, err := g.splitKeys(t.Key())
+	if err != nil {
+		return nil, err
+	}
+	ez := reflect.Zero(et)
+	f, err := def.diff(ez)
+	if err != nil {
+		return nil, err
+	}
+	var kfs map[interface{}]diffF
+	if specials != nil {
+		kfs = make(map[interface{}]diffF, len(specials))
+		for k, c := range specials {
+			kf, err := c.diff(ez)
+			if err != nil {
+				return nil, err
+			}
+			kfs[k] = kf
+		}
+	}
+	if f == nil && kfs == nil {
+		return nil, nil
+	}
+
+	keyF := func(k reflect.Value) diffF {
+		if kfs != nil {
+			if kf, exists := kfs[k.Interface()]; exists {
+				return kf
+			}
+		}
+		return f
+	}
+
+	return func(out, l, r unsafe.Pointer) {
+		ml := reflect.NewAt(t, l).Elem()
+		mr := reflect.NewAt(t, r).Elem()
+		mo := reflect.NewAt(t, out).Elem()
+		if ml.IsNil() {
+			mo.SetZero()
+			return
+		}
+		if mo.IsNil() {
+			mo.Set(reflect.MakeMapWithSize(t, ml.Len()))
+		} else {
+			// Keys that are skipped are left as they are in out.
+			iter := mo.MapRange()
+			for iter.Next() {
+				if k := iter.Key(); keyF(k) != nil {
+					mo.SetMapIndex(k, reflect.Value{})
+				}
+			}
+		}
+
+		iter := ml.MapRange()
+		for iter.Next() {
+			k := iter.Key()
+			f := keyF(k)
+			if f == nil {
+				continue
+			}
+
+			lv := reflect.New(et)
+			lv.Elem().Set(iter.Value())
+			rv := reflect.New(et)
+			if re := mr.MapIndex(k); re.IsValid() {
+				rv.Elem().Set(re)
+			}
+			ov := reflect.New(et)
+			f(ov.UnsafePointer(), lv.UnsafePointer(), rv.UnsafePointer())
+			mo.SetMapIndex(k, ov.Elem())
+		}
+	}, nil
+}
+
+// diffStruct generates a closure to subtract a struct.
+func (g *generator) diffStruct(v reflect.Value) (diffF, error) {
+	t := v.Type()
+	field, check, err := g.fields(t)
+	if err != nil {
+		return nil, err
+	}
+
+	type offsetF struct {
+		offset uintptr
+		f      diffF
+	}
+	var offsetFs []offsetF
+	for i := 0; i < t.NumField(); i++ {
+		c := field(i)
+		f, err := c.diff(v.Field(i))
+		if err != nil {
+			return nil, err
+		}
+		if f != nil {
+			offsetFs = append(offsetFs, offsetF{t.Field(i).Offset, f})
+		}
+	}
+
+	if err := check(); err != nil {
+		return nil, err
+	}
+
+	if len(offsetFs) == 0 {
+		return nil, nil
+	}
+	return func(out, l, r unsafe.Pointer) {
+		for _, of := range offsetFs {
+			of.f(fieldByOffset(out, of.offset), fieldByOffset(l, of.offset), fieldByOffset(r, of.offset))
+		}
+	}, nil
+}
@@ -0,0 +1,170 @@
+package mergetyp
+
+import (
+	"reflect"
+	"testing"
+)
+
+type diffInner struct {
+	N    int32
+	Name string
+}
+
+type diffType struct {
+	Count   int64
+	Ratio   float64
+	Seen    bool
+	Buckets [3]uint64
+	Inner   diffInner
+	Ptr     *diffInner
+	Elems   []diffInner
+	Labels  map[string]int
+	Max     int
+	List    *recursive
+	private []int
+}
+
+func TestGenDiff(t *testing.T) {
+	opts := []func(*Config) error{
+		WithSlowerMapsUnsafely(),
+		SkipFields("Inner>Name", "Ptr>Name", "Elems>Name", "Max", "private", `Labels{"skip"}`),
+	}
+	diff := MustGenDiff(new(diffType), opts...)
+	merge := MustGen(new(diffType), opts...)
+
+	prev := diffType{
+		Count:   10,
+		Ratio:   0.5,
+		Buckets: [3]uint64{1, 2, 3},
+		Inner:   diffInner{1, "prev"},
+		Elems:   []diffInner{{1, "a"}},
+		Labels:  map[string]int{"a": 1, "skip": 1},
+		Max:     3,
+		List:    &recursive{1, nil},
+		private: []int{1},
+	}
+	cur := diffType{
+		Count:   15,
+		Ratio:   0.75,
+		Seen:    true,
+		Buckets: [3]uint64{1, 4, 9},
+		Inner:   diffInner{3, "cur"},
+		Ptr:     &diffInner{4, "cur"},
+		Elems:   []diffInner{{2, "a"}, {5, "b"}},
+		Labels:  map[string]int{"a": 3, "b": 2, "skip": 5},
+		Max:     4,
+		List:    &recursive{3, &recursive{2, nil}},
+		private: []int{2},
+	}
+
+	var delta diffType
+	diff(&delta, &cur, &prev)
+
+	exp := diffType{
+		Count:   5,
+		Ratio:   0.25,
+		Seen:    true,
+		Buckets: [3]uint64{0, 2, 6},
+		Inner:   diffInner{2, ""},
+		Ptr:     &diffInner{4, ""},
+		Elems:   []diffInner{{1, ""}, {5, ""}},
+		Labels:  map[string]int{"a": 2, "b": 2},
+		List:    &recursive{2, &recursive{2, nil}},
+	}
+	if !reflect.DeepEqual(delta, exp) {
+		t.Fatalf("got %#v != exp %#v", delta, exp)
+	}
+
+	// Merging the delta back gets us the current value, for everything
+	// that is merged.
+	merge(&delta, &prev)
+	exp = cur
+	exp.Inner.Name = ""
+	exp.Ptr = &diffInner{4, ""}
+	exp.Elems = []diffInner{{2, ""}, {5, ""}}
+	exp.Labels = map[string]int{"a": 3, "b": 2}
+	exp.Max = 0
+	exp.private = nil
+	if !reflect.DeepEqual(delta, exp) {
+		t.Errorf("got %#v != exp %#v", delta, exp)
+	}
+
+	for _, test := range []struct {
+		name string
+		opts []func(*Config) error
+	}{
+		{"strings", []func(*Config) error{WithSlowerMapsUnsafely()}},
+		{"unknown field", append(opts, SkipField("Missing"))},
+	} {
+		if _, err := GenDiff(new(diffType), test.opts...); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+
+	// Values that are not summed cannot be subtracted, and are copied
+	// from the left: a gauge that went down is its current value.
+	gauge := append(opts, WithStrategy("Count", StrategyMax), WithStrategy("Seen", StrategyMin))
+	delta = diffType{}
+	MustGenDiff(new(diffType), gauge...)(&delta, &diffType{Count: 4, Seen: true}, &diffType{Count: 9})
+	if delta.Count != 4 || !delta.Seen {
+		t.Errorf("gauge got %d and %v, exp 4 and true", delta.Count, delta.Seen)
+	}
+
+	// Complex numbers cannot be merged with StrategyMax, so they cannot
+	// be diffed with it either.
+	if _, err := GenDiff(new(complex128), WithStrategy("", StrategyMax)); err == nil {
+		t.Error("complex max: expected error")
+	}
+
+	// Skipped keys are left as they are in out, and other keys not in l
+	// are deleted.
+	delta = diffType{Labels: map[string]int{"skip": 7, "gone": 1}}
+	diff(&delta, &cur, &prev)
+	if exp := map[string]int{"a": 2, "b": 2, "skip": 7}; !reflect.DeepEqual(delta.Labels, exp) {
+		t.Errorf("reused labels got %v != exp %v", delta.Labels, exp)
+	}
+}
+
+type diffCounts struct {
+	N int64
+}
+
+type diffStrategies struct {
+	A diffCounts
+	B diffCounts
+}
+
+func TestGenDiffStrategiesPerField(t *testing.T) {
+	// Fields of the same struct type diffed with different strategies
+	// must not share a function.
+	for _, field := range []string{"A", "B"} {
+		opts := []func(*Config) error{WithStrategy(field, StrategyMax)}
+		diff := MustGenDiff(new(diffStrategies), opts...)
+		merge := MustGen(new(diffStrategies), opts...)
+
+		prev := diffStrategies{diffCounts{3}, diffCounts{5}}
+		cur := diffStrategies{diffCounts{9}, diffCounts{7}}
+		var delta diffStrategies
+		diff(&delta, &cur, &prev)
+		merge(&delta, &prev)
+		if delta != cur {
+			t.Errorf("%s max: got %+v != exp %+v", field, delta, cur)
+		}
+	}
+}
+
+func TestGenDiffAnonymous(t *testing.T) {
+	var l, r anonymous
+	l.A.X, l.A.P = 5, []int64{7}
+	l.B.Y, l.B.Q, l.B.Z = 3, []int64{9}, 8
+	r.A.X, r.A.P = 1, []int64{2}
+	r.B.Y, r.B.Q, r.B.Z = 1, []int64{4}, 5
+
+	var out, exp anonymous
+	MustGenDiff(new(anonymous))(&out, &l, &r)
+	exp.A.X, exp.A.P = 4, []int64{5}
+	exp.B.Y, exp.B.Q, exp.B.Z = 2, []int64{5}, 3
+	if !reflect.DeepEqual(out, exp) {
+		t.Errorf("got %+v != exp %+v", out, exp)
+	}
+}
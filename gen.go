@@ -1,10 +1,12 @@
 package mergetyp
 
 import (
+	"cmp"
 	"errors"
 	"fmt"
 	"reflect"
 	"strings"
+	"sync"
 	"unsafe"
 
 	"github.com/twmb/vali"
@@ -16,31 +18,148 @@ import (
 
 type generator struct {
 	structFs map[string]*mergeF
+	cloneFs  map[reflect.Type]*mergeF // like structFs, for GenClone
+	diffFs   map[structKey]*diffF     // like structFs, for GenDiff
+	resetFs  map[structKey]*resetF    // like structFs, for GenReset
 	useMap   bool
-	skips    []string
+	atomic   bool
+	rules    []rule
+	strategy Strategy
+	filters  []FieldFilter
+	nameTag  string
+	path     []string
+	locking  bool // if true, sync.Mutex and sync.RWMutex fields are locks
+	mergers  map[reflect.Type]mergeF
+
+	// resetters are like mergers, for GenReset.
+	resetters map[reflect.Type]resetF
+
+	// If interfaces is true, interfaces are merged by their dynamic
+	// types, with mergers generated lazily while holding lazy unless
+	// they are registered in impls.
+	interfaces bool
+	mismatch   MismatchPolicy
+	impls      map[reflect.Type]ifaceImpls
+	lazy       *sync.Mutex
+
+	// If trees is non-nil, map[string]any, []any, and any are merged as
+	// dynamic trees.
+	trees *treeConfig
+
+	// If lock is true, we are generating for a struct field named by a
+	// lock path.
+	lock bool
+
+	// If skip is non-empty, we are generating for something that is
+	// skipped, and this is why.
+	skip string
+	// If plan is non-nil, we record what we generate into it.
+	plan *PlanNode
 }
 
 type mergeF = func(unsafe.Pointer, unsafe.Pointer)
 
+// structKey is a struct type and the strategy its numbers are diffed or
+// reset with, which is what a struct's function depends on.
+type structKey struct {
+	t reflect.Type
+	s Strategy
+}
+
 func fieldByOffset(u unsafe.Pointer, o uintptr) unsafe.Pointer {
 	return unsafe.Pointer(uintptr(u) + o)
 }
 
 // gen is the entry point for all recursion; it generates a closure to merge
-// an arbitrary value (with some exceptions that return errors).
+// an arbitrary value (with some exceptions that return errors). This returns
+// nil if there is nothing to merge.
 func (g *generator) gen(v reflect.Value) (mergeF, error) {
-	if len(g.skips) > 0 {
+	if g.plan != nil {
+		g.plan.Type = v.Type().String()
+	}
+	if g.skip != "" {
+		g.plan.skipped(g.skip)
+		return nil, nil
+	}
+	if g.lock {
+		return nil, fmt.Errorf("unable to lock with %v: lock paths must name struct fields", v.Type())
+	}
+	g.plan.set(v.Kind(), g.strategy)
+
+	f, err := g.genKind(v)
+	if err == nil && f == nil {
+		g.plan.skipped("nothing to merge")
+	}
+	return f, err
+}
+
+// at returns a generator for a value below a pointer, recording into n.
+func (g *generator) at(n *PlanNode) *generator {
+	if n == nil {
+		return g
+	}
+	c := *g
+	c.plan = n
+	return &c
+}
+
+// genKind generates the closure to merge a value based on its kind.
+func (g *generator) genKind(v reflect.Value) (mergeF, error) {
+	// Registered mergers take precedence over everything.
+	if f, ok := g.mergers[v.Type()]; ok {
+		if len(g.rules) > 0 {
+			return nil, fmt.Errorf("unable to skip or select fields in %v, which has a registered merger", v.Type())
+		}
+		g.plan.custom()
+		return f, nil
+	}
+	if f, ok, err := g.genTree(v); ok {
+		return f, err
+	}
+
+	// Types from sync/atomic, times, and big numbers are structs, but we
+	// must merge them as a whole rather than reaching into them.
+	if f, ok, err := g.genSyncAtomic(v); ok {
+		return f, err
+	}
+	if f, ok, err := g.genTime(v); ok {
+		return f, err
+	}
+	if f, ok, err := g.genBig(v); ok {
+		return f, err
+	}
+
+	if len(g.rules) > 0 {
 		switch v.Kind() {
 		// We can only contain skips on structs or types that may
-		// contain structs.
-		case reflect.Slice, reflect.Struct, reflect.Array, reflect.Ptr:
+		// contain structs, or on selectable arrays, slices, and maps.
+		case reflect.Slice, reflect.Struct, reflect.Array, reflect.Ptr, reflect.Map:
 		default:
-			return nil, fmt.Errorf("unable to skip fields on kind %v", v.Kind())
+			return nil, fmt.Errorf("unable to skip or select fields on kind %v", v.Kind())
+		}
+	}
+
+	// Atomic merges change how we merge primitives, pointers, and what
+	// we cannot merge atomically at all.
+	if g.atomic {
+		if f, ok, err := g.genAtomic(v); ok {
+			return f, err
+		}
+	}
+
+	// Strategies other than summing only change how we merge
+	// primitives.
+	if g.strategy != StrategySum {
+		if f, ok, err := genStrategy(v.Kind(), g.strategy); ok {
+			return f, err
 		}
 	}
 
 	switch v.Kind() {
 	case reflect.Interface:
+		if g.interfaces {
+			return g.genInterface(v)
+		}
 		// TODO we can probably create a Merger interface and test/use
 		// that here.
 		return nil, errors.New("it is impossible to merge two types that are interfaces (unable to determine concrete type)")
@@ -98,7 +217,7 @@ func (g *generator) gen(v reflect.Value) (mergeF, error) {
 	case reflect.Ptr:
 		et := v.Type().Elem()
 		ez := reflect.Zero(et)
-		f, err := g.gen(ez)
+		f, err := g.at(g.plan.child("*")).gen(ez)
 		if err != nil {
 			return nil, err
 		}
@@ -132,7 +251,7 @@ func (g *generator) gen(v reflect.Value) (mergeF, error) {
 	case reflect.Struct:
 		// We may skip recursive struct fields selectively, so we have
 		// to recursive until we will not skip recusrive fields.
-		if len(g.skips) > 0 {
+		if len(g.rules) > 0 {
 			return g.genStruct(v)
 		}
 
@@ -140,17 +259,35 @@ func (g *generator) gen(v reflect.Value) (mergeF, error) {
 		// we will fill in when we return up.
 		typ := v.Type()
 		name := typ.PkgPath() + "." + typ.Name()
+		if g.strategy != StrategySum {
+			name += "#" + g.strategy.String()
+		}
 		pf, exists := g.structFs[name]
 		if exists {
+			g.plan.recursive()
 			return func(l, r unsafe.Pointer) { (*pf)(l, r) }, nil
 		}
 
+		// Field filters are consulted with the path to a field, which
+		// differs every time we see a type at a new path. With
+		// filters, we only reuse a struct's function to stop
+		// recursion, and forget it once generated. We do the same
+		// when planning so that every path is planned in full.
 		pf = new(mergeF)
 		g.structFs[name] = pf
+		if len(g.filters) > 0 || g.plan != nil {
+			defer delete(g.structFs, name)
+		}
 		f, err := g.genStruct(v)
 		if err != nil {
 			return nil, err
 		}
+		// If there is nothing to merge, later uses of this type must
+		// not call a nil function. Recursive types always have
+		// something to merge: the recursive field.
+		if f == nil {
+			delete(g.structFs, name)
+		}
 		*pf = f
 		return f, nil
 
@@ -165,18 +302,151 @@ func (g *generator) gen(v reflect.Value) (mergeF, error) {
 	}
 }
 
+// genStrategy generates the closure to merge a primitive with a strategy
+// other than summing. This returns false if the kind is not a primitive.
+func genStrategy(k reflect.Kind, s Strategy) (mergeF, bool, error) {
+	switch k {
+	case reflect.Bool:
+		if s == StrategyMin {
+			return func(l, r unsafe.Pointer) {
+				if !*(*bool)(r) {
+					*(*bool)(l) = false
+				}
+			}, true, nil
+		}
+		return func(l, r unsafe.Pointer) {
+			if *(*bool)(r) {
+				*(*bool)(l) = true
+			}
+		}, true, nil
+	case reflect.Int:
+		return genOrdered[int](s), true, nil
+	case reflect.Int8:
+		return genOrdered[int8](s), true, nil
+	case reflect.Int16:
+		return genOrdered[int16](s), true, nil
+	case reflect.Int32:
+		return genOrdered[int32](s), true, nil
+	case reflect.Int64:
+		return genOrdered[int64](s), true, nil
+	case reflect.Uint:
+		return genOrdered[uint](s), true, nil
+	case reflect.Uint8:
+		return genOrdered[uint8](s), true, nil
+	case reflect.Uint16:
+		return genOrdered[uint16](s), true, nil
+	case reflect.Uint32:
+		return genOrdered[uint32](s), true, nil
+	case reflect.Uint64:
+		return genOrdered[uint64](s), true, nil
+	case reflect.Uintptr:
+		return genOrdered[uintptr](s), true, nil
+	case reflect.Float32:
+		return genOrdered[float32](s), true, nil
+	case reflect.Float64:
+		return genOrdered[float64](s), true, nil
+	case reflect.Complex64:
+		return genComplex[complex64](s)
+	case reflect.Complex128:
+		return genComplex[complex128](s)
+	}
+	return nil, false, nil
+}
+
+// genComplex returns a keep or overwrite closure for complex numbers, which
+// are not ordered.
+func genComplex[T complex64 | complex128](s Strategy) (mergeF, bool, error) {
+	if s == StrategyMin || s == StrategyMax {
+		return nil, true, fmt.Errorf("unable to merge complex numbers with strategy %v", s)
+	}
+	return genNonZero[T](s), true, nil
+}
+
+// genOrdered returns a closure for an ordered primitive for a strategy other
+// than summing.
+func genOrdered[T cmp.Ordered](s Strategy) mergeF {
+	switch s {
+	case StrategyMin:
+		return func(l, r unsafe.Pointer) {
+			if *(*T)(r) < *(*T)(l) {
+				*(*T)(l) = *(*T)(r)
+			}
+		}
+	case StrategyMax:
+		return func(l, r unsafe.Pointer) {
+			if *(*T)(r) > *(*T)(l) {
+				*(*T)(l) = *(*T)(r)
+			}
+		}
+	default:
+		return genNonZero[T](s)
+	}
+}
+
+// genNonZero returns a keep or overwrite closure.
+func genNonZero[T comparable](s Strategy) mergeF {
+	var zero T
+	if s == StrategyKeep {
+		return func(l, r unsafe.Pointer) {
+			if *(*T)(l) == zero {
+				*(*T)(l) = *(*T)(r)
+			}
+		}
+	}
+	return func(l, r unsafe.Pointer) {
+		if *(*T)(r) != zero {
+			*(*T)(l) = *(*T)(r)
+		}
+	}
+}
+
+// replaces returns whether merging r into l with a strategy other than
+// summing results in r.
+func replaces[T cmp.Ordered](s Strategy, l, r T) bool {
+	var zero T
+	switch s {
+	case StrategyMin:
+		return r < l
+	case StrategyMax:
+		return r > l
+	case StrategyKeep:
+		return l == zero && r != zero
+	default:
+		return r != zero && r != l
+	}
+}
+
 // genMap generates the closure to merge an map. This is the most unsafe
 // function; we have to do a bunch of trickery with values we should not be
 // accessing.
 func (g *generator) genMap(v reflect.Value) (mergeF, error) {
-	// First, we generate the merge function for the map values.
+	// First, we generate the merge functions for the map values: one for
+	// any key, and one for every key selected by our rules.
 	et := v.Type().Elem()
 
+	def, specials, err := g.splitKeys(v.Type().Key())
+	if err != nil {
+		return nil, err
+	}
 	ez := reflect.Zero(et)
-	f, err := g.gen(ez)
+	f, err := def.gen(ez)
 	if err != nil {
 		return nil, err
 	}
+	var kfs map[interface{}]mergeF
+	if specials != nil {
+		kfs = make(map[interface{}]mergeF, len(specials))
+		for k, c := range specials {
+			kf, err := c.gen(ez)
+			if err != nil {
+				return nil, err
+			}
+			kfs[k] = kf
+		}
+	}
+	if f == nil && kfs == nil {
+		return nil, nil
+	}
 
 	// The function we calculate for merging is based off a pointer to the
 	// value. If the map's value _is_ a pointer, we do not need to take its
@@ -235,6 +505,18 @@ func (g *generator) genMap(v reflect.Value) (mergeF, error) {
 
 		rks := rv.MapKeys()
 		for _, rk := range rks {
+			// Keys whose values we do not merge are left alone,
+			// even if left does not have the key.
+			f := f
+			if kfs != nil {
+				if kf, exists := kfs[rk.Interface()]; exists {
+					f = kf
+				}
+			}
+			if f == nil {
+				continue
+			}
+
 			lkv := lv.MapIndex(rk)
 
 			// If left's map value does not exist for a right's
@@ -270,14 +552,27 @@ func (g *generator) genMap(v reflect.Value) (mergeF, error) {
 
 // genArray generates the closure to merge an array.
 func (g *generator) genArray(v reflect.Value) (mergeF, error) {
+	fast := len(g.rules) == 0 && g.strategy == StrategySum && !g.atomic
 	len := uintptr(v.Len())
 	et := v.Type().Elem()
 	size := et.Size()
 	end := len * size
 
+	// Rules and other strategies cannot use the fast paths below; we
+	// force the default case.
+	kind := et.Kind()
+	if !fast || g.mergers[et] != nil {
+		kind = reflect.Invalid
+	}
+	if fastKind(kind) && g.plan != nil {
+		n := g.plan.child("[*]")
+		n.Type = et.String()
+		n.fast()
+	}
+
 	// This massive block is a bunch of duplication to use faster functions
 	// for primitive types.
-	switch et.Kind() {
+	switch kind {
 	case reflect.Bool:
 		return func(l, r unsafe.Pointer) {
 			for offset := uintptr(0); offset < end; offset += size {
@@ -373,27 +668,77 @@ func (g *generator) genArray(v reflect.Value) (mergeF, error) {
 
 	// Our default cases is recursion, per usual.
 	default:
+		def, specials, err := g.splitIndices(int(len))
+		if err != nil {
+			return nil, err
+		}
 		z := reflect.Zero(et)
-		f, err := g.gen(z)
+		f, err := def.gen(z)
 		if err != nil {
 			return nil, err
 		}
-		if f == nil {
+
+		if specials == nil {
+			if f == nil {
+				return nil, nil
+			}
+			return func(l, r unsafe.Pointer) {
+				for offset := uintptr(0); offset < end; offset += size {
+					f(fieldByOffset(l, offset), fieldByOffset(r, offset))
+				}
+			}, nil
+		}
+
+		// If some indices were selected, we lay out a function per
+		// element.
+		sfs, err := genSpecials(specials, z)
+		if err != nil {
+			return nil, err
+		}
+		fs := make([]mergeF, len)
+		any := false
+		for i := range fs {
+			fs[i] = f
+			if sf, exists := sfs[i]; exists {
+				fs[i] = sf
+			}
+			any = any || fs[i] != nil
+		}
+		if !any {
 			return nil, nil
 		}
 		return func(l, r unsafe.Pointer) {
-			for offset := uintptr(0); offset < end; offset += size {
-				f(fieldByOffset(l, offset), fieldByOffset(r, offset))
+			for i, f := range fs {
+				if f != nil {
+					offset := uintptr(i) * size
+					f(fieldByOffset(l, offset), fieldByOffset(r, offset))
+				}
 			}
 		}, nil
 	}
 }
 
+// genSpecials generates the merge functions for elements of an array or
+// slice that were selected by index.
+func genSpecials(specials map[int]*generator, z reflect.Value) (map[int]mergeF, error) {
+	fs := make(map[int]mergeF, len(specials))
+	for index, c := range specials {
+		f, err := c.gen(z)
+		if err != nil {
+			return nil, err
+		}
+		fs[index] = f
+	}
+	return fs, nil
+}
+
 // genSlice generates the closure to merge a slice.
 func (g *generator) genSlice(v reflect.Value) (mergeF, error) {
 	et := v.Type().Elem()
 	size := et.Size()
 
+	// Sums are commutative, so the fast paths below can swap the longest
+	// slice to the left before merging.
 	normalize := func(l, r unsafe.Pointer) (*reflect.SliceHeader, *reflect.SliceHeader, uintptr) {
 		hl := ((*reflect.SliceHeader)(l))
 		hr := ((*reflect.SliceHeader)(r))
@@ -411,9 +756,40 @@ func (g *generator) genSlice(v reflect.Value) (mergeF, error) {
 		return hl, hr, end
 	}
 
+	// The default path does not swap, and merges the elements both
+	// slices have.
+	bounds := func(l, r unsafe.Pointer) (*sliceHeader, *sliceHeader, uintptr) {
+		hl := (*sliceHeader)(l)
+		hr := (*sliceHeader)(r)
+		return hl, hr, uintptr(min(hl.len, hr.len)) * size
+	}
+
+	// Other strategies and registered mergers are not commutative, so
+	// the default path merges into the left elements, then adopts the
+	// right slice with the merged elements copied into it if the right
+	// slice is longer.
+	st := v.Type()
+	adopt := func(l, r unsafe.Pointer) {
+		hl := (*sliceHeader)(l)
+		hr := (*sliceHeader)(r)
+		if hr.len > hl.len {
+			reflect.Copy(reflect.NewAt(st, r).Elem(), reflect.NewAt(st, l).Elem())
+			*hl, *hr = *hr, *hl
+		}
+	}
+
 	// Just like in array above, we special case slices of primitive types
 	// so that the merge function generated is faster.
-	switch et.Kind() {
+	kind := et.Kind()
+	if len(g.rules) > 0 || g.strategy != StrategySum || g.mergers[et] != nil {
+		kind = reflect.Invalid
+	}
+	if fastKind(kind) && g.plan != nil {
+		n := g.plan.child("[*]")
+		n.Type = et.String()
+		n.fast()
+	}
+	switch kind {
 	case reflect.Bool:
 		return func(l, r unsafe.Pointer) {
 			hl, hr, end := normalize(l, r)
@@ -523,52 +899,143 @@ func (g *generator) genSlice(v reflect.Value) (mergeF, error) {
 		}, nil
 
 	default:
+		def, specials, err := g.splitIndices(-1)
+		if err != nil {
+			return nil, err
+		}
 		z := reflect.Zero(et)
-		f, err := g.gen(z)
+		f, err := def.gen(z)
 		if err != nil {
 			return nil, err
 		}
-		if f == nil {
-			return nil, nil
+
+		if specials == nil {
+			if f == nil {
+				return nil, nil
+			}
+			return func(l, r unsafe.Pointer) {
+				hl, hr, end := bounds(l, r)
+				for offset := uintptr(0); offset < end; offset += size {
+					f(unsafe.Add(hl.data, offset), unsafe.Add(hr.data, offset))
+				}
+				adopt(l, r)
+			}, nil
+		}
+
+		// Slices have no fixed length, so we check for selected
+		// indices as we go.
+		sfs, err := genSpecials(specials, z)
+		if err != nil {
+			return nil, err
 		}
 		return func(l, r unsafe.Pointer) {
-			hl, hr, end := normalize(l, r)
-			for offset := uintptr(0); offset < end; offset += size {
-				f(unsafe.Pointer(hl.Data+offset), unsafe.Pointer(hr.Data+offset))
+			hl, hr, end := bounds(l, r)
+			for i, offset := 0, uintptr(0); offset < end; i, offset = i+1, offset+size {
+				ef := f
+				if sf, exists := sfs[i]; exists {
+					ef = sf
+				}
+				if ef != nil {
+					ef(unsafe.Add(hl.data, offset), unsafe.Add(hr.data, offset))
+				}
 			}
-
+			adopt(l, r)
 		}, nil
 	}
 }
 
-// genStruct generates a closure to merge a struct.
-func (g *generator) genStruct(v reflect.Value) (mergeF, error) {
-	// We actually care about skips in structs!
+// fieldName returns the name a field is addressed by in paths: the name in
+// the field's nameTag tag if we have one and the field is tagged, otherwise
+// the Go field name.
+func (g *generator) fieldName(sf reflect.StructField) string {
+	if g.nameTag == "" {
+		return sf.Name
+	}
+	name := sf.Tag.Get(g.nameTag)
+	if idx := strings.IndexByte(name, ','); idx != -1 {
+		name = name[:idx]
+	}
+	if name == "" || name == "-" {
+		return sf.Name
+	}
+	return name
+}
+
+// keep returns whether all field filters allow merging the field at path.
+func (g *generator) keep(path []string, sf reflect.StructField) bool {
+	for _, filter := range g.filters {
+		if !filter(path, sf) {
+			return false
+		}
+	}
+	return true
+}
+
+// fields splits our rules for the fields of struct t. This returns a function
+// that returns the generator for the i'th field, which must be called in
+// field order, and a function that returns an error if any rule at this level
+// does not name a field of t. Callers check the rules after recursing into
+// fields so that errors deeper in a path are reported first.
+func (g *generator) fields(t reflect.Type) (func(int) *generator, func() error, error) {
+	// We actually care about rules in structs!
 	//
-	// For all skips that do not have `>`, we require the field to exist,
-	// and then we skip it.
+	// Every rule at a struct level must name a field at this level. Rules
+	// that end at a field apply to it (skipping it, selecting it, or
+	// changing its strategy), while rules that continue are passed with
+	// the remnants when recursing down that field.
 	//
-	// For all skips that have `>`, trim past the `>` and pass the remnants
-	// when recursing down that field. `foo>bar` and `foo>baz` would pass
-	// `bar, baz` when going down `foo`.
-	skipMyLevel := make(map[string]struct{})
-	skipNextLevel := make(map[string][]string)
-
-	for _, skip := range g.skips {
-		idx := strings.IndexByte(skip, '>')
-		if idx == -1 {
-			skipMyLevel[skip] = struct{}{}
-			continue
+	// Fields promoted from embedded structs can be named directly, as
+	// with Go selectors; we rewrite those to go through the embedded
+	// fields before matching.
+	rules, err := g.promote(t, g.rules)
+	if err != nil {
+		return nil, nil, err
+	}
+	used := make([]bool, len(rules))
+	matched := make([][]rule, t.NumField())
+	for i := range matched {
+		name := g.fieldName(t.Field(i))
+		for j, r := range rules {
+			if r.steps[0].matches(i, name) {
+				used[j] = true
+				matched[i] = append(matched[i], r)
+			}
+		}
+	}
+
+	field := func(i int) *generator {
+		sf := t.Field(i)
+		name := g.fieldName(sf)
+		c := g.sub(matched[i], nil)
+		c.plan = g.plan.child(name)
+		path := append(g.path[:len(g.path):len(g.path)], name)
+		if c.skip == "" && !g.keep(path, sf) {
+			c.skip = "skipped by field filter"
 		}
+		c.path = path
 
-		if idx == 0 || len(skip) < idx+1 {
-			return nil, errors.New("invalid skip: empty field name")
+		// Locks are never merged (nor cloned, diffed, or reset).
+		if g.locking && (sf.Type == mutexType || sf.Type == rwMutexType) {
+			c.lock = true
+		}
+		if c.lock {
+			c.skip = "lock held while merging"
 		}
+		return c
+	}
 
-		field := skip[:idx]
-		subFields := skip[idx+1:]
+	// We require that the rules be an exact match: all fields named must
+	// have been seen.
+	check := func() error { return g.unseen(t, rules, used) }
+	return field, check, nil
+}
 
-		skipNextLevel[field] = append(skipNextLevel[field], subFields)
+// genStruct generates a closure to merge a struct.
+func (g *generator) genStruct(v reflect.Value) (mergeF, error) {
+	t := v.Type()
+	field, check, err := g.fields(t)
+	if err != nil {
+		return nil, err
 	}
 
 	// I expect that most structs to merge will contain primitive number
@@ -588,20 +1055,34 @@ func (g *generator) genStruct(v reflect.Value) (mergeF, error) {
 		f      mergeF
 	}
 	var offsetFs []offsetF
+	var nas notAtomic
+	var lockers []func(unsafe.Pointer) sync.Locker
 
 	// If we add a single field, we return a function. If we skip all
 	// fields, we return nil. Levels higher up will bubble up the nil
 	// as appropriate.
 	added := 0
-	t := v.Type()
 	for i := 0; i < t.NumField(); i++ {
 		sf := t.Field(i)
-		if _, exists := skipMyLevel[sf.Name]; exists {
-			delete(skipMyLevel, sf.Name)
-			continue
+		c := field(i)
+		if c.lock {
+			lk, err := locker(sf)
+			if err != nil {
+				return nil, err
+			}
+			lockers = append(lockers, lk)
+		}
+
+		// Fields that are skipped, that have rules below them, that
+		// have a different strategy, that are merged atomically, or
+		// that have a registered merger cannot use the fast path; we
+		// force the default case.
+		kind := sf.Type.Kind()
+		if c.skip != "" || len(c.rules) > 0 || c.strategy != StrategySum || c.atomic || c.mergers[sf.Type] != nil {
+			kind = reflect.Invalid
 		}
 
-		switch sf.Type.Kind() {
+		switch kind {
 		case reflect.Bool:
 			bools = append(bools, sf.Offset)
 		case reflect.Int:
@@ -633,37 +1114,43 @@ func (g *generator) genStruct(v reflect.Value) (mergeF, error) {
 		case reflect.Complex128:
 			c128s = append(c128s, sf.Offset)
 		default:
-			f, err := (&generator{
-				structFs: g.structFs,
-				useMap:   g.useMap,
-				skips:    skipNextLevel[sf.Name],
-			}).gen(v.Field(i))
-			delete(skipNextLevel, sf.Name)
+			f, err := c.gen(v.Field(i))
 			if err != nil {
+				// We report every field that cannot be merged
+				// atomically at once.
+				var na notAtomic
+				if errors.As(err, &na) {
+					nas = append(nas, na...)
+					continue
+				}
 				return nil, err
 			}
 			if f == nil {
 				continue
 			}
 			offsetFs = append(offsetFs, offsetF{sf.Offset, f})
+			added++
+			continue
+		}
+		if c.plan != nil {
+			c.plan.Type = sf.Type.String()
+			c.plan.fast()
 		}
 		added++
 	}
 
-	// We require that the skip fields be an exact match: all fields to
-	// skip must have been seen.
-	if len(skipMyLevel) > 0 {
-		return nil, errors.New("did not see all fields that we were required to skip")
+	if err := check(); err != nil {
+		return nil, err
 	}
-	if len(skipNextLevel) > 0 {
-		return nil, errors.New("did not see all fields names for next level skips")
+	if len(nas) > 0 {
+		return nil, nas
 	}
 
 	if added == 0 {
 		return nil, nil
 	}
 
-	return func(l, r unsafe.Pointer) {
+	f := func(l, r unsafe.Pointer) {
 		for _, offset := range bools {
 			if *(*bool)(fieldByOffset(r, offset)) {
 				*(*bool)(fieldByOffset(l, offset)) = true
@@ -714,5 +1201,9 @@ func (g *generator) genStruct(v reflect.Value) (mergeF, error) {
 		for _, of := range offsetFs {
 			of.f(fieldByOffset(l, of.offset), fieldByOffset(r, of.offset))
 		}
-	}, nil
+	}
+	if len(lockers) == 0 {
+		return f, nil
+	}
+	return lockAround(lockers, f), nil
 }
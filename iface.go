@@ -0,0 +1,333 @@
+package mergetyp
+
+import (
+	"fmt"
+	"reflect"
+	"strconv"
+	"sync"
+	"unsafe"
+)
+
+// This file contains the logic to merge interfaces by their dynamic types; see
+// WithInterfaceMerges.
+
+// MismatchPolicy is what to do when merging two non-nil interfaces that hold
+// different dynamic types.
+type MismatchPolicy uint8
+
+const (
+	// MismatchKeepLeft leaves the left interface as it is.
+	MismatchKeepLeft MismatchPolicy = iota
+	// MismatchTakeRight sets the left interface to the right interface.
+	MismatchTakeRight
+	// MismatchPanic panics, because merge functions cannot return errors.
+	MismatchPanic
+)
+
+func (p MismatchPolicy) String() string {
+	switch p {
+	case MismatchKeepLeft:
+		return "keep left"
+	case MismatchTakeRight:
+		return "take right"
+	case MismatchPanic:
+		return "panic"
+	default:
+		return "MismatchPolicy(" + strconv.Itoa(int(p)) + ")"
+	}
+}
+
+// UnknownPolicy is what to do when merging two interfaces that hold the same
+// dynamic type, if that type was not registered with WithInterfaceImpls.
+type UnknownPolicy uint8
+
+const (
+	// UnknownGenerate generates a function for the dynamic type while
+	// merging, as with interfaces without registered types.
+	UnknownGenerate UnknownPolicy = iota
+	// UnknownKeepLeft leaves the left interface as it is.
+	UnknownKeepLeft
+	// UnknownTakeRight sets the left interface to the right interface.
+	UnknownTakeRight
+	// UnknownPanic panics, because merge functions cannot return errors.
+	UnknownPanic
+)
+
+func (p UnknownPolicy) String() string {
+	switch p {
+	case UnknownGenerate:
+		return "generate"
+	case UnknownKeepLeft:
+		return "keep left"
+	case UnknownTakeRight:
+		return "take right"
+	case UnknownPanic:
+		return "panic"
+	default:
+		return "UnknownPolicy(" + strconv.Itoa(int(p)) + ")"
+	}
+}
+
+// ifaceImpls are the types registered for an interface type with
+// WithInterfaceImpls.
+type ifaceImpls struct {
+	unknown UnknownPolicy
+	impls   []reflect.Type
+}
+
+// dispatcher merges interfaces of type t by their dynamic types, generating
+// and caching merge functions for dynamic types as they are seen.
+type dispatcher struct {
+	g        *generator
+	t        reflect.Type
+	mismatch MismatchPolicy
+	unknown  UnknownPolicy
+
+	// table maps the type word of every registered dynamic type to its
+	// function. It is built before merging and never modified after.
+	table map[unsafe.Pointer]*dynamic[mergeF]
+
+	// fs are the functions for other dynamic types.
+	fs *dynamicFs[mergeF]
+}
+
+// dynamic is how to merge (or reset, ...) one dynamic type; f is nil if there
+// is nothing to do.
+type dynamic[F any] struct {
+	t reflect.Type
+	f F
+}
+
+// dynamicFs generates and caches functions for the dynamic types of
+// interfaces of type t as they are seen.
+type dynamicFs[F any] struct {
+	t    reflect.Type
+	what string // what we generate, for panics
+	lazy *sync.Mutex
+	gen  func(reflect.Type) (F, error)
+
+	// fs maps the type word of an interface to its *dynamic[F]. For
+	// non-empty interfaces, the type word is the itab, which is unique
+	// per interface and dynamic type.
+	fs sync.Map
+}
+
+// typeWord returns the type word of an interface of type t holding a zero
+// value of type it.
+func typeWord(t, it reflect.Type) unsafe.Pointer {
+	iv := reflect.New(t)
+	iv.Elem().Set(reflect.Zero(it))
+	return (*ifaceWords)(iv.UnsafePointer()).typ
+}
+
+// register generates functions for the types registered for t with
+// WithInterfaceImpls, returning any error now rather than panicking later.
+func (d *dynamicFs[F]) register(impls map[reflect.Type]ifaceImpls) error {
+	for _, it := range impls[d.t].impls {
+		f, err := d.gen(it)
+		if err != nil {
+			return fmt.Errorf("unable to %s %v held in %v: %w", d.what, it, d.t, err)
+		}
+		d.fs.Store(typeWord(d.t, it), &dynamic[F]{it, f})
+	}
+	return nil
+}
+
+// lookup returns the function for the dynamic type of the non-nil interface
+// at p, generating it if this is the first time we see the type.
+func (d *dynamicFs[F]) lookup(p unsafe.Pointer) *dynamic[F] {
+	w := (*ifaceWords)(p).typ
+	if df, ok := d.fs.Load(w); ok {
+		return df.(*dynamic[F])
+	}
+
+	// Generating shares state with every other dynamic type in the
+	// same function, so only one may generate at a time.
+	d.lazy.Lock()
+	defer d.lazy.Unlock()
+	if df, ok := d.fs.Load(w); ok {
+		return df.(*dynamic[F])
+	}
+	t := reflect.NewAt(d.t, p).Elem().Elem().Type()
+	f, err := d.gen(t)
+	if err != nil {
+		panic(fmt.Sprintf("mergetyp: unable to %s %v held in %v: %v", d.what, t, d.t, err))
+	}
+	df := &dynamic[F]{t, f}
+	d.fs.Store(w, df)
+	return df
+}
+
+// genInterface generates the closure to merge an interface by its dynamic
+// type.
+func (g *generator) genInterface(v reflect.Value) (mergeF, error) {
+	g.plan.dynamic()
+	d, err := g.dispatcher(v.Type(), g.mismatch)
+	if err != nil {
+		return nil, err
+	}
+	return d.merge, nil
+}
+
+// dispatcher returns a dispatcher for interfaces of type t. Types registered
+// for t are generated now, and we recognize them by the type word of an
+// interface holding them.
+func (g *generator) dispatcher(t reflect.Type, mismatch MismatchPolicy) (*dispatcher, error) {
+	c := *g
+	c.plan = nil
+	d := &dispatcher{
+		g:        &c,
+		t:        t,
+		mismatch: mismatch,
+		fs: &dynamicFs[mergeF]{
+			t:    t,
+			what: "merge",
+			lazy: g.lazy,
+			gen:  func(t reflect.Type) (mergeF, error) { return c.gen(reflect.Zero(t)) },
+		},
+	}
+	if ii, ok := g.impls[t]; ok {
+		d.unknown = ii.unknown
+		d.table = make(map[unsafe.Pointer]*dynamic[mergeF], len(ii.impls))
+		for _, it := range ii.impls {
+			f, err := c.at(g.plan.child("(" + it.String() + ")")).gen(reflect.Zero(it))
+			if err != nil {
+				return nil, fmt.Errorf("unable to merge %v held in %v: %w", it, t, err)
+			}
+			d.table[typeWord(t, it)] = &dynamic[mergeF]{it, f}
+		}
+	}
+	return d, nil
+}
+
+// resetInterface generates the closure to reset an interface by its dynamic
+// type. The interface keeps its dynamic type, and what it holds is reset.
+func (g *generator) resetInterface(t reflect.Type) (resetF, error) {
+	c := *g
+	d := &dynamicFs[resetF]{
+		t:    t,
+		what: "reset",
+		lazy: g.lazy,
+		gen: func(t reflect.Type) (resetF, error) {
+			f, _, err := c.reset(reflect.Zero(t))
+			return f, err
+		},
+	}
+	if err := d.register(g.impls); err != nil {
+		return nil, err
+	}
+	return func(p unsafe.Pointer) {
+		if (*ifaceWords)(p).typ == nil {
+			return
+		}
+		df := d.lookup(p)
+		if df.f == nil {
+			return
+		}
+		// As when merging, we never modify what an interface holds
+		// in place.
+		v := reflect.NewAt(t, p).Elem()
+		n := reflect.New(df.t)
+		n.Elem().Set(v.Elem())
+		df.f(n.UnsafePointer())
+		v.Set(n.Elem())
+	}, nil
+}
+
+// cloneInterface generates the closure to deep copy an interface by its
+// dynamic type.
+func (g *generator) cloneInterface(t reflect.Type) (mergeF, error) {
+	c := *g
+	d := &dynamicFs[mergeF]{
+		t:    t,
+		what: "clone",
+		lazy: g.lazy,
+		gen:  func(t reflect.Type) (mergeF, error) { return c.clone(reflect.Zero(t)) },
+	}
+	if err := d.register(g.impls); err != nil {
+		return nil, err
+	}
+	return func(dst, src unsafe.Pointer) {
+		dv := reflect.NewAt(t, dst).Elem()
+		sv := reflect.NewAt(t, src).Elem()
+		if sv.IsNil() {
+			dv.SetZero()
+			return
+		}
+		df := d.lookup(src)
+		if df.f == nil {
+			dv.Set(sv)
+			return
+		}
+		s := reflect.New(df.t)
+		s.Elem().Set(sv.Elem())
+		n := reflect.New(df.t)
+		df.f(n.UnsafePointer(), s.UnsafePointer())
+		dv.Set(n.Elem())
+	}, nil
+}
+
+func (d *dispatcher) merge(l, r unsafe.Pointer) {
+	wl := (*ifaceWords)(l)
+	wr := (*ifaceWords)(r)
+	// As with missing map keys, a nil interface adopts the right
+	// interface, and a nil right interface has nothing to merge.
+	if wl.typ == nil {
+		*wl = *wr
+		return
+	}
+	if wr.typ == nil {
+		return
+	}
+	if wl.typ != wr.typ {
+		d.mismatched(l, r)
+		return
+	}
+	df, ok := d.table[wl.typ]
+	if !ok {
+		switch d.unknown {
+		case UnknownKeepLeft:
+			return
+		case UnknownTakeRight:
+			*wl = *wr
+			return
+		case UnknownPanic:
+			panic(fmt.Sprintf("mergetyp: unable to merge unregistered %s held in %v", d.dynamicType(l), d.t))
+		}
+		df = d.fs.lookup(l)
+	}
+	if df.f == nil {
+		return
+	}
+
+	// Values in interfaces are immutable: the data word may be shared
+	// with copies of the interface, or may even point to read only
+	// memory. We merge copies of both sides and store the result.
+	lv := reflect.NewAt(d.t, l).Elem()
+	rv := reflect.NewAt(d.t, r).Elem()
+	nl := reflect.New(df.t)
+	nl.Elem().Set(lv.Elem())
+	nr := reflect.New(df.t)
+	nr.Elem().Set(rv.Elem())
+	df.f(nl.UnsafePointer(), nr.UnsafePointer())
+	lv.Set(nl.Elem())
+}
+
+// mismatched handles non-nil interfaces with different dynamic types.
+func (d *dispatcher) mismatched(l, r unsafe.Pointer) {
+	switch d.mismatch {
+	case MismatchTakeRight:
+		*(*ifaceWords)(l) = *(*ifaceWords)(r)
+	case MismatchPanic:
+		panic(fmt.Sprintf("mergetyp: unable to merge %v holding %s with %s", d.t, d.dynamicType(l), d.dynamicType(r)))
+	}
+}
+
+// dynamicType returns the name of the dynamic type of the interface at p.
+func (d *dispatcher) dynamicType(p unsafe.Pointer) string {
+	v := reflect.NewAt(d.t, p).Elem()
+	if v.IsNil() {
+		return "nil"
+	}
+	return v.Elem().Type().String()
+}
@@ -0,0 +1,195 @@
+package mergetyp
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type clickPayload struct {
+	Count int
+	Max   int
+}
+
+type viewPayload struct {
+	Millis int64
+}
+
+type ifaceEvent struct {
+	Count    int
+	Payload  any
+	Stringer fmt.Stringer
+}
+
+type ifaceStringer struct{ N int }
+
+func (ifaceStringer) String() string { return "stringer" }
+
+func TestWithInterfaceMerges(t *testing.T) {
+	f := MustGen(new(ifaceEvent),
+		WithInterfaceMerges(MismatchKeepLeft),
+		WithStrategy("Payload", StrategyMax),
+	)
+
+	l := ifaceEvent{Count: 1, Payload: clickPayload{1, 5}, Stringer: ifaceStringer{1}}
+	shared := l.Payload // shares what l's interface holds
+	r := ifaceEvent{Count: 1, Payload: clickPayload{2, 3}, Stringer: ifaceStringer{2}}
+	f(&l, &r)
+	exp := ifaceEvent{Count: 2, Payload: clickPayload{2, 5}, Stringer: ifaceStringer{3}}
+	if !reflect.DeepEqual(l, exp) {
+		t.Errorf("got %+v != exp %+v", l, exp)
+	}
+	if shared != (clickPayload{1, 5}) {
+		t.Errorf("merging modified a shared interface value: %+v", shared)
+	}
+
+	// Pointers are merged as pointers, with our strategy.
+	l = ifaceEvent{Payload: &viewPayload{1}}
+	r = ifaceEvent{Payload: &viewPayload{2}}
+	f(&l, &r)
+	if got := l.Payload.(*viewPayload).Millis; got != 2 {
+		t.Errorf("got %d != exp 2", got)
+	}
+
+	for _, test := range []struct {
+		mismatch MismatchPolicy
+		l, r     any
+		exp      any
+	}{
+		{MismatchKeepLeft, clickPayload{1, 1}, &viewPayload{1}, clickPayload{1, 1}},
+		{MismatchKeepLeft, nil, clickPayload{1, 1}, clickPayload{1, 1}},
+		{MismatchTakeRight, clickPayload{1, 1}, &viewPayload{1}, &viewPayload{1}},
+		{MismatchTakeRight, clickPayload{1, 1}, nil, clickPayload{1, 1}},
+		{MismatchPanic, nil, clickPayload{1, 1}, clickPayload{1, 1}},
+		{MismatchTakeRight, nil, nil, nil},
+	} {
+		f := MustGen(new(ifaceEvent), WithInterfaceMerges(test.mismatch))
+		l, r := ifaceEvent{Payload: test.l}, ifaceEvent{Payload: test.r}
+		f(&l, &r)
+		if !reflect.DeepEqual(l.Payload, test.exp) {
+			t.Errorf("%v %T %T: got %#v != exp %#v", test.mismatch, test.l, test.r, l.Payload, test.exp)
+		}
+	}
+
+	panics := func(name string, fn func()) {
+		defer func() {
+			if recover() == nil {
+				t.Errorf("%s: expected panic", name)
+			}
+		}()
+		fn()
+	}
+	f = MustGen(new(ifaceEvent), WithInterfaceMerges(MismatchPanic))
+	panics("mismatch", func() {
+		f(&ifaceEvent{Payload: clickPayload{}}, &ifaceEvent{Payload: &viewPayload{}})
+	})
+	panics("unmergeable", func() {
+		f(&ifaceEvent{Payload: "a"}, &ifaceEvent{Payload: "b"})
+	})
+
+	// Dynamic types are generated once even when first seen by many
+	// merges at once.
+	f = MustGen(new(ifaceEvent), WithInterfaceMerges(MismatchPanic))
+	var wg sync.WaitGroup
+	for i := 0; i < 8; i++ {
+		wg.Add(1)
+		go func() {
+			defer wg.Done()
+			l := ifaceEvent{Payload: clickPayload{1, 1}}
+			f(&l, &ifaceEvent{Payload: clickPayload{1, 1}})
+			if l.Payload != (clickPayload{2, 2}) {
+				t.Errorf("got %+v != exp {2 2}", l.Payload)
+			}
+		}()
+	}
+	wg.Wait()
+
+	// Interfaces can be cloned and reset, such as for accumulators, and
+	// a nil total adopts the first value added.
+	acc := MustNewAccumulator[ifaceEvent](WithInterfaceMerges(MismatchKeepLeft))
+	acc.Add(&ifaceEvent{Count: 1, Payload: &viewPayload{1}})
+	acc.Add(&ifaceEvent{Count: 1, Payload: &viewPayload{2}})
+	if got := acc.Swap(); got.Count != 2 || got.Payload.(*viewPayload).Millis != 3 {
+		t.Errorf("accumulated %+v, exp a count of 2 and 3 millis", *got)
+	}
+	var c ifaceEvent
+	src := ifaceEvent{Payload: &viewPayload{1}, Stringer: ifaceStringer{1}}
+	MustGenClone(new(ifaceEvent), WithInterfaceMerges(MismatchKeepLeft))(&c, &src)
+	if !reflect.DeepEqual(c, src) || c.Payload == src.Payload {
+		t.Errorf("clone got %+v, exp a deep copy of %+v", c, src)
+	}
+	MustGenReset(new(ifaceEvent), WithInterfaceMerges(MismatchKeepLeft))(&src)
+	if exp := (ifaceEvent{Payload: &viewPayload{}, Stringer: ifaceStringer{}}); !reflect.DeepEqual(src, exp) {
+		t.Errorf("reset got %+v != exp %+v", src, exp)
+	}
+
+	for _, opts := range [][]func(*Config) error{
+		{WithInterfaceMerges(MismatchPolicy(100))},
+		{WithInterfaceMerges(MismatchKeepLeft), WithAtomicMerges()},
+	} {
+		if _, err := Gen(new(ifaceEvent), opts...); err == nil {
+			t.Error("expected error")
+		}
+	}
+}
+
+func TestWithInterfaceImpls(t *testing.T) {
+	anyType := reflect.TypeFor[any]()
+	clickType := reflect.TypeFor[clickPayload]()
+	viewType := reflect.TypeFor[*viewPayload]()
+
+	plan, err := Plan(new(ifaceEvent), WithInterfaceImpls(anyType, UnknownPanic, clickType, viewType))
+	if err != nil {
+		t.Fatal(err)
+	}
+	var names []string
+	for _, c := range plan.Children[1].Children {
+		names = append(names, c.Name)
+	}
+	if exp := []string{"(mergetyp.clickPayload)", "(*mergetyp.viewPayload)"}; !reflect.DeepEqual(names, exp) {
+		t.Errorf("got plan children %v != exp %v", names, exp)
+	}
+
+	for _, test := range []struct {
+		unknown UnknownPolicy
+		exp     any
+	}{
+		{UnknownGenerate, ifaceStringer{3}},
+		{UnknownKeepLeft, ifaceStringer{1}},
+		{UnknownTakeRight, ifaceStringer{2}},
+	} {
+		f := MustGen(new(ifaceEvent), WithInterfaceImpls(anyType, test.unknown, clickType, viewType))
+		l := ifaceEvent{Payload: clickPayload{1, 1}}
+		f(&l, &ifaceEvent{Payload: clickPayload{1, 1}})
+		if l.Payload != (clickPayload{2, 2}) {
+			t.Errorf("%v: got %+v != exp {2 2}", test.unknown, l.Payload)
+		}
+		l = ifaceEvent{Payload: ifaceStringer{1}}
+		f(&l, &ifaceEvent{Payload: ifaceStringer{2}})
+		if l.Payload != test.exp {
+			t.Errorf("%v: got %+v != exp %+v", test.unknown, l.Payload, test.exp)
+		}
+	}
+
+	f := MustGen(new(ifaceEvent), WithInterfaceImpls(anyType, UnknownPanic, clickType))
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic for unregistered type")
+			}
+		}()
+		f(&ifaceEvent{Payload: 1}, &ifaceEvent{Payload: 2})
+	}()
+
+	for _, opt := range []func(*Config) error{
+		WithInterfaceImpls(clickType, UnknownPanic),
+		WithInterfaceImpls(anyType, UnknownPolicy(100)),
+		WithInterfaceImpls(reflect.TypeFor[fmt.Stringer](), UnknownPanic, clickType),
+		WithInterfaceImpls(anyType, UnknownPanic, reflect.TypeFor[string]()),
+	} {
+		if _, err := Gen(new(ifaceEvent), opt); err == nil {
+			t.Error("expected error")
+		}
+	}
+}
@@ -0,0 +1,165 @@
+// Package fieldpath parses the field paths used to name fields in
+// go-mergetyp options, such as SkipField.
+//
+// A path is a series of struct fields separated by `>`. Any field can be
+// followed by selectors: `[N]` selects one element of an array or slice,
+// `[*]` selects every element, and `{key}` selects one key of a map. The
+// first field may be omitted if the path begins with a selector, which
+// selects into the input type itself.
+package fieldpath
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Kind is the kind of a step in a path.
+type Kind uint8
+
+const (
+	// Field is a struct field.
+	Field Kind = iota
+	// Index is a single array or slice element, [N].
+	Index
+	// Any is every array or slice element, [*].
+	Any
+	// Key is a single map key, {key}.
+	Key
+)
+
+// Step is one level of a path.
+type Step struct {
+	Kind  Kind
+	Name  string // field name for Field, key literal for Key
+	Index int    // index for Index
+}
+
+func (s Step) String() string {
+	switch s.Kind {
+	case Index:
+		return "[" + strconv.Itoa(s.Index) + "]"
+	case Any:
+		return "[*]"
+	case Key:
+		return "{" + s.Name + "}"
+	default:
+		return s.Name
+	}
+}
+
+// Parse parses a path into its steps.
+func Parse(path string) ([]Step, error) {
+	var steps []Step
+	invalid := func(why string) error {
+		return fmt.Errorf("invalid path %q: %s", path, why)
+	}
+
+	s := path
+	for first := true; ; first = false {
+		n := strings.IndexAny(s, ">[{")
+		if n == -1 {
+			n = len(s)
+		}
+		if n > 0 {
+			steps = append(steps, Step{Kind: Field, Name: s[:n]})
+		} else if !first || len(s) == 0 || s[0] == '>' {
+			return nil, errors.New("invalid path: empty field name")
+		}
+		s = s[n:]
+
+		for len(s) > 0 && (s[0] == '[' || s[0] == '{') {
+			if s[0] == '[' {
+				end := strings.IndexByte(s, ']')
+				if end == -1 {
+					return nil, invalid("unterminated [")
+				}
+				inner := s[1:end]
+				s = s[end+1:]
+				if inner == "*" {
+					steps = append(steps, Step{Kind: Any})
+					continue
+				}
+				index, err := strconv.Atoi(inner)
+				if err != nil || index < 0 {
+					return nil, invalid("index must be * or a non-negative integer")
+				}
+				steps = append(steps, Step{Kind: Index, Index: index})
+				continue
+			}
+
+			// Keys may be quoted strings, which can contain
+			// anything, including a `}`.
+			var key string
+			if len(s) > 1 && (s[1] == '"' || s[1] == '`') {
+				q, err := strconv.QuotedPrefix(s[1:])
+				if err != nil {
+					return nil, invalid("bad quoted key")
+				}
+				key = q
+			} else if end := strings.IndexByte(s, '}'); end != -1 {
+				key = s[1:end]
+			}
+			if key == "" || len(s) < len(key)+2 || s[len(key)+1] != '}' {
+				return nil, invalid("unterminated or empty {")
+			}
+			steps = append(steps, Step{Kind: Key, Name: key})
+			s = s[len(key)+2:]
+		}
+
+		if len(s) == 0 {
+			return steps, nil
+		}
+		if s[0] != '>' {
+			return nil, invalid(fmt.Sprintf("unexpected %q after selector", s[0]))
+		}
+		s = s[1:]
+	}
+}
+
+// Format formats steps back into a path.
+func Format(steps []Step) string {
+	var sb strings.Builder
+	for i, s := range steps {
+		if i > 0 && s.Kind == Field {
+			sb.WriteByte('>')
+		}
+		sb.WriteString(s.String())
+	}
+	return sb.String()
+}
+
+// KeyKind returns whether keys of maps with key kind k can be selected.
+func KeyKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.String, reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		return true
+	}
+	return false
+}
+
+// ParseKey parses the literal of a {key} selector for map keys of kind k,
+// where integer keys are bits wide. Strings must be quoted; integers and bools
+// are bare. The key is returned as a string, int64, uint64, or bool, and this
+// returns false if the literal is invalid or k is not a KeyKind.
+func ParseKey(lit string, k reflect.Kind, bits int) (interface{}, bool) {
+	var key interface{}
+	var err error
+	switch k {
+	case reflect.String:
+		key, err = strconv.Unquote(lit)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		key, err = strconv.ParseInt(lit, 0, bits)
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		key, err = strconv.ParseUint(lit, 0, bits)
+	case reflect.Bool:
+		key, err = strconv.ParseBool(lit)
+	default:
+		return nil, false
+	}
+	return key, err == nil
+}
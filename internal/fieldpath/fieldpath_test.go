@@ -0,0 +1,48 @@
+package fieldpath
+
+import (
+	"reflect"
+	"testing"
+)
+
+func TestParse(t *testing.T) {
+	for _, test := range []struct {
+		path string
+		exp  []Step
+	}{
+		{"Foo", []Step{{Kind: Field, Name: "Foo"}}},
+		{"Foo>bar", []Step{{Kind: Field, Name: "Foo"}, {Kind: Field, Name: "bar"}}},
+		{"Buckets[63]", []Step{{Kind: Field, Name: "Buckets"}, {Kind: Index, Index: 63}}},
+		{"Shards[*]>Count", []Step{{Kind: Field, Name: "Shards"}, {Kind: Any}, {Kind: Field, Name: "Count"}}},
+		{`Labels{"a>}b"}{3}`, []Step{{Kind: Field, Name: "Labels"}, {Kind: Key, Name: `"a>}b"`}, {Kind: Key, Name: "3"}}},
+		{"[1]>A", []Step{{Kind: Index, Index: 1}, {Kind: Field, Name: "A"}}},
+	} {
+		got, err := Parse(test.path)
+		if err != nil {
+			t.Errorf("%q: unexpected err %v", test.path, err)
+			continue
+		}
+		if !reflect.DeepEqual(got, test.exp) {
+			t.Errorf("%q: got %v != exp %v", test.path, got, test.exp)
+		}
+		if formatted := Format(got); formatted != test.path {
+			t.Errorf("%q: formatted back as %q", test.path, formatted)
+		}
+	}
+
+	for _, path := range []string{
+		"",
+		">Foo",
+		"Foo>",
+		"Foo[",
+		"Foo[-1]",
+		"Foo[x]",
+		"Foo{}",
+		`Foo{"a}`,
+		"Foo[1]x",
+	} {
+		if _, err := Parse(path); err == nil {
+			t.Errorf("%q: expected error", path)
+		}
+	}
+}
@@ -0,0 +1,68 @@
+package mergetyp
+
+import (
+	"fmt"
+	"reflect"
+	"sync"
+	"unsafe"
+)
+
+// This file contains the logic to lock structs while merging them; see
+// WithLocking.
+
+var (
+	mutexType   = reflect.TypeFor[sync.Mutex]()
+	rwMutexType = reflect.TypeFor[sync.RWMutex]()
+	lockerType  = reflect.TypeFor[sync.Locker]()
+)
+
+// locker returns a function that returns the lock for a struct field given a
+// pointer to the struct.
+func locker(sf reflect.StructField) (func(unsafe.Pointer) sync.Locker, error) {
+	offset := sf.Offset
+	switch sf.Type {
+	case mutexType:
+		return func(p unsafe.Pointer) sync.Locker { return (*sync.Mutex)(fieldByOffset(p, offset)) }, nil
+	case rwMutexType:
+		return func(p unsafe.Pointer) sync.Locker { return (*sync.RWMutex)(fieldByOffset(p, offset)) }, nil
+	}
+	if !reflect.PointerTo(sf.Type).Implements(lockerType) {
+		return nil, fmt.Errorf("unable to lock with field %s: *%v does not implement sync.Locker", sf.Name, sf.Type)
+	}
+	t := sf.Type
+	return func(p unsafe.Pointer) sync.Locker {
+		return reflect.NewAt(t, fieldByOffset(p, offset)).Interface().(sync.Locker)
+	}, nil
+}
+
+// lockAround returns a closure that locks both sides of a struct with all
+// lockers while merging with f. To avoid deadlocks between concurrent merges
+// of the same two values, we always lock the value at the lower address
+// first.
+func lockAround(lockers []func(unsafe.Pointer) sync.Locker, f mergeF) mergeF {
+	return func(l, r unsafe.Pointer) {
+		first, second := l, r
+		if uintptr(r) < uintptr(l) {
+			first, second = r, l
+		}
+		for _, lk := range lockers {
+			lk(first).Lock()
+		}
+		if second != first {
+			for _, lk := range lockers {
+				lk(second).Lock()
+			}
+		}
+
+		f(l, r)
+
+		if second != first {
+			for i := len(lockers) - 1; i >= 0; i-- {
+				lockers[i](second).Unlock()
+			}
+		}
+		for i := len(lockers) - 1; i >= 0; i-- {
+			lockers[i](first).Unlock()
+		}
+	}
+}
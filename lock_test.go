@@ -0,0 +1,90 @@
+package mergetyp
+
+import (
+	"strings"
+	"sync"
+	"testing"
+)
+
+type lockedInner struct {
+	mu sync.RWMutex
+	N  int
+}
+
+type spinLock struct{ held int32 }
+
+func (s *spinLock) Lock()   {}
+func (s *spinLock) Unlock() {}
+
+type lockedType struct {
+	mu    sync.Mutex
+	spin  spinLock
+	Count int64
+	Hist  []int
+	Inner lockedInner
+}
+
+func TestWithLocking(t *testing.T) {
+	merge := MustGen(new(lockedType), WithLocking("spin"))
+
+	// Merging two values into each other concurrently must not deadlock
+	// nor race.
+	var a, b lockedType
+	var wg sync.WaitGroup
+	for g := 0; g < 4; g++ {
+		wg.Add(1)
+		go func(g int) {
+			defer wg.Done()
+			for i := 0; i < 200; i++ {
+				if g%2 == 0 {
+					merge(&a, &b)
+				} else {
+					merge(&b, &a)
+				}
+				a.mu.Lock()
+				a.Count++
+				a.Inner.N++
+				a.mu.Unlock()
+			}
+		}(g)
+	}
+	wg.Wait()
+	if a.Count+b.Count == 0 {
+		t.Error("nothing was merged")
+	}
+
+	// Locks are never merged, and are reported as such in plans.
+	plan, err := Plan(new(lockedType), WithLocking("spin"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := plan.String(); !strings.Contains(s, "mu sync.Mutex: skip (lock held while merging)") ||
+		!strings.Contains(s, "spin mergetyp.spinLock: skip (lock held while merging)") ||
+		!strings.Contains(s, "mu sync.RWMutex: skip (lock held while merging)") {
+		t.Errorf("locks not skipped in plan:\n%s", s)
+	}
+
+	// Locks are neither reset nor cloned, even in types that could
+	// otherwise be reset or cloned byte for byte.
+	type plain struct {
+		mu sync.Mutex
+		N  int64
+	}
+	held := plain{N: 1}
+	held.mu.Lock()
+	MustGenReset(new(plain), WithLocking())(&held)
+	if held.N != 0 || held.mu.TryLock() {
+		t.Errorf("reset got N %d and an unlocked mutex, exp 0 and a held mutex", held.N)
+	}
+	var c plain
+	MustGenClone(new(plain), WithLocking())(&c, &held)
+	if !c.mu.TryLock() {
+		t.Error("clone copied a held mutex")
+	}
+
+	for _, path := range []string{"Count", "Hist[0]", "missing"} {
+		if _, err := Gen(new(lockedType), WithLocking(path)); err == nil {
+			t.Errorf("%s: expected error", path)
+		}
+	}
+}
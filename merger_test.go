@@ -0,0 +1,149 @@
+package mergetyp
+
+import (
+	"reflect"
+	"testing"
+	"unsafe"
+)
+
+// highWater is merged by keeping the larger value, which we only know from
+// its registered merger.
+type highWater int64
+
+// sketch is a type we pretend to not own, which must not be merged field by
+// field.
+type sketch struct {
+	name string
+	seen []int
+}
+
+func (s *sketch) Merge(o *sketch) { s.seen = append(s.seen, o.seen...) }
+
+type mergerType struct {
+	Count  int
+	Field  highWater
+	Arr    [2]highWater
+	Slice  []highWater
+	Ptr    *highWater
+	Map    map[string]highWater
+	Sketch sketch
+}
+
+func TestWithTypeMerger(t *testing.T) {
+	hw := func(v highWater) *highWater { return &v }
+	l := mergerType{
+		Count:  1,
+		Field:  5,
+		Arr:    [2]highWater{1, 5},
+		Slice:  []highWater{1, 5},
+		Ptr:    hw(5),
+		Map:    map[string]highWater{"a": 5},
+		Sketch: sketch{"l", []int{1}},
+	}
+	r := mergerType{
+		Count:  2,
+		Field:  3,
+		Arr:    [2]highWater{3, 3},
+		Slice:  []highWater{3, 3, 3},
+		Ptr:    hw(3),
+		Map:    map[string]highWater{"a": 3, "b": 3},
+		Sketch: sketch{"r", []int{2}},
+	}
+
+	opts := []func(*Config) error{
+		WithMergerFor(func(l, r *highWater) {
+			if *r > *l {
+				*l = *r
+			}
+		}),
+		WithTypeMerger(reflect.TypeFor[sketch](), func(l, r unsafe.Pointer) {
+			(*sketch)(l).Merge((*sketch)(r))
+		}),
+		WithSlowerMapsUnsafely(),
+	}
+	f, err := Gen(&l, opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f(&l, &r)
+
+	exp := mergerType{
+		Count:  3,
+		Field:  5,
+		Arr:    [2]highWater{3, 5},
+		Slice:  []highWater{3, 5, 3},
+		Ptr:    hw(5),
+		Map:    map[string]highWater{"a": 5, "b": 3},
+		Sketch: sketch{"l", []int{1, 2}},
+	}
+	if !reflect.DeepEqual(l, exp) {
+		t.Errorf("got %+v != exp %+v", l, exp)
+	}
+
+	// Mergers are always called with the left value first, even when
+	// the right slice is longer.
+	type keepFirst struct{ S []highWater }
+	kf := MustGen(new(keepFirst), WithMergerFor(func(l, r *highWater) {}))
+	kl, kr := keepFirst{[]highWater{1}}, keepFirst{[]highWater{5, 6}}
+	kf(&kl, &kr)
+	if exp := []highWater{1, 6}; !reflect.DeepEqual(kl.S, exp) {
+		t.Errorf("got %v != exp %v", kl.S, exp)
+	}
+
+	// The top level type can be registered, and registering again
+	// replaces the prior merger.
+	var calls int
+	top := MustGen(new(sketch),
+		WithMergerFor(func(l, r *sketch) { t.Error("replaced merger called") }),
+		WithMergerFor(func(l, r *sketch) { calls++ }),
+	)
+	top(new(sketch), new(sketch))
+	if calls != 1 {
+		t.Errorf("got %d calls != exp 1", calls)
+	}
+
+	plan, err := Plan(new(mergerType), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	for _, c := range plan.Children {
+		if c.Name == "Sketch" && c.Action != ActionCustom {
+			t.Errorf("sketch got action %v != exp %v", c.Action, ActionCustom)
+		}
+	}
+
+	// Types with registered mergers are never reached into, even when
+	// they could otherwise be cloned or reset byte for byte.
+	hwMerger := WithMergerFor(func(l, r *highWater) {})
+	type hwType struct {
+		N  int64
+		HW highWater
+	}
+	if _, err := GenDiff(new(hwType), hwMerger); err == nil {
+		t.Error("expected diff error")
+	}
+	if _, err := GenClone(new(hwType), hwMerger); err == nil {
+		t.Error("expected clone error")
+	}
+	if _, err := GenReset(new(hwType), hwMerger); err == nil {
+		t.Error("expected reset error")
+	}
+	v := hwType{N: 1, HW: 5}
+	MustGenReset(new(hwType), hwMerger, WithResetterFor(func(v *highWater) { *v = -1 }))(&v)
+	if exp := (hwType{N: 0, HW: -1}); v != exp {
+		t.Errorf("reset got %+v != exp %+v", v, exp)
+	}
+
+	for _, opt := range []func(*Config) error{
+		WithTypeMerger(nil, func(l, r unsafe.Pointer) {}),
+		WithMergerFor[sketch](nil),
+		WithTypeResetter(nil, func(unsafe.Pointer) {}),
+	} {
+		if _, err := Gen(new(mergerType), opt, WithSlowerMapsUnsafely()); err == nil {
+			t.Error("expected error")
+		}
+	}
+	if _, err := Gen(new(mergerType), append(opts, SkipField("Sketch>name"))...); err == nil {
+		t.Error("expected error skipping within a registered type")
+	}
+}
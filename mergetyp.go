@@ -11,7 +11,8 @@
 //
 // Using Gen can help future proof merging changes when the struct may grow
 // over time. Further, the field blacklisting functionality can eliminate
-// batches of tedious code.
+// batches of tedious code, and the field whitelisting functionality can
+// ensure that new fields are not merged until they are opted in.
 //
 // Recursive types are supported, as is skipping fields selectively in
 // recursive types until a base limit. It is not yet possible to skip a field
@@ -20,7 +21,11 @@ package mergetyp
 
 import (
 	"errors"
+	"fmt"
 	"reflect"
+	"strconv"
+	"strings"
+	"sync"
 	"unsafe"
 )
 
@@ -35,19 +40,50 @@ type ifaceWords struct {
 // config. These functions can change the configuration to skip fields and
 // whatnot.
 type Config struct {
-	skips     []string
+	rules     []rule
+	strategy  Strategy
+	filters   []FieldFilter
+	nameTag   string
 	unsafeMap bool
+	atomic    bool
+	locking   bool
+	mergers   map[reflect.Type]mergeF
+	resetters map[reflect.Type]resetF
+
+	interfaces bool
+	mismatch   MismatchPolicy
+	impls      map[reflect.Type]ifaceImpls
+	trees      *treeConfig
 }
 
 // SkipFields is like SkipField, but allows for specifying multiple fields to
 // be skipped.
 func SkipFields(fields ...string) func(*Config) error {
 	return func(c *Config) error {
-		c.skips = append(c.skips, fields...)
+		for _, field := range fields {
+			if err := c.addRule(actSkip, 0, field); err != nil {
+				return err
+			}
+		}
 		return nil
 	}
 }
 
+// addRule parses path and adds it as a rule to c.
+func (c *Config) addRule(act action, strategy Strategy, path string) error {
+	steps, err := parsePath(path)
+	if err != nil {
+		return err
+	}
+	c.rules = append(c.rules, rule{
+		act:      act,
+		strategy: strategy,
+		steps:    steps,
+		path:     path,
+	})
+	return nil
+}
+
 // WithSlowerMapsUnsafely enables merging maps. This package returns a function
 // that internally uses unsafe.Pointer to merge fields directly. To merge maps,
 // we have to convert that unsafe.Pointer back into an interface{} and then
@@ -60,6 +96,235 @@ func WithSlowerMapsUnsafely() func(*Config) error {
 	}
 }
 
+// WithAtomicMerges generates merge functions that update the left value with
+// sync/atomic operations, such that many goroutines can merge into one
+// shared left value without a lock. The left value must only be modified
+// through merging while it is shared, and the right value must not be shared.
+//
+// Integers of 32 and 64 bits (including int, uint, and uintptr) are added
+// atomically, and floats are updated with compare-and-swap loops, as are
+// integers merged with strategies other than StrategySum. A nil pointer in
+// the left value is swapped for the right value's pointer with
+// compare-and-swap.
+//
+// Everything else cannot be merged atomically: bools, 8 and 16 bit integers,
+// complex numbers, times, big numbers, slices, maps, and interfaces.
+// Generating a merge function for a type containing any of these is an error
+// naming every such field, and these fields must be skipped. On 32-bit
+// platforms, 64-bit fields must be 64-bit aligned, as documented in
+// sync/atomic.
+func WithAtomicMerges() func(*Config) error {
+	return func(c *Config) error {
+		c.atomic = true
+		return nil
+	}
+}
+
+// WithTypeMerger registers f to merge every value of type t, wherever t is
+// found: as the top level type, as a struct field, and as what is within
+// pointers, arrays, slices, and maps. This allows merging types that cannot be
+// merged field by field, such as types from other packages that must only be
+// modified through their methods.
+//
+// The function is called with pointers to the left and right values, and
+// must merge the right value into the left. A registered merger takes
+// precedence over how a type would otherwise be merged, including strategies
+// and atomic merges: f alone decides how to merge and whether doing so is
+// safe for concurrent use. Fields within t cannot be skipped or selected.
+// Registering a type again replaces the prior function. GenClone and GenDiff
+// fail for t, and GenReset fails for t unless WithTypeResetter registers how
+// to reset it.
+//
+// WithMergerFor is the type safe version of this function.
+func WithTypeMerger(t reflect.Type, f func(l, r unsafe.Pointer)) func(*Config) error {
+	return func(c *Config) error {
+		if t == nil || f == nil {
+			return errors.New("invalid nil type or function for type merger")
+		}
+		if c.mergers == nil {
+			c.mergers = make(map[reflect.Type]mergeF)
+		}
+		c.mergers[t] = f
+		return nil
+	}
+}
+
+// WithMergerFor registers f to merge every value of type T, as described in
+// WithTypeMerger. For example, to merge a sketch type that we do not own:
+//
+//     mergetyp.WithMergerFor(func(l, r *sketch.Sketch) { l.Merge(r) })
+func WithMergerFor[T any](f func(l, r *T)) func(*Config) error {
+	if f == nil {
+		return WithTypeMerger(reflect.TypeFor[T](), nil)
+	}
+	return WithTypeMerger(reflect.TypeFor[T](), func(l, r unsafe.Pointer) { f((*T)(l), (*T)(r)) })
+}
+
+// WithTypeResetter registers f to reset every value of type t for GenReset,
+// wherever t is found, as described in WithTypeMerger. GenReset cannot reach
+// into types that have a registered merger, and fails for them unless they
+// also have a registered resetter. GenClone and GenDiff fail for types that
+// have a registered merger.
+//
+// WithResetterFor is the type safe version of this function.
+func WithTypeResetter(t reflect.Type, f func(p unsafe.Pointer)) func(*Config) error {
+	return func(c *Config) error {
+		if t == nil || f == nil {
+			return errors.New("invalid nil type or function for type resetter")
+		}
+		if c.resetters == nil {
+			c.resetters = make(map[reflect.Type]resetF)
+		}
+		c.resetters[t] = f
+		return nil
+	}
+}
+
+// WithResetterFor registers f to reset every value of type T for GenReset, as
+// described in WithTypeResetter.
+func WithResetterFor[T any](f func(v *T)) func(*Config) error {
+	if f == nil {
+		return WithTypeResetter(reflect.TypeFor[T](), nil)
+	}
+	return WithTypeResetter(reflect.TypeFor[T](), func(p unsafe.Pointer) { f((*T)(p)) })
+}
+
+// WithInterfaceMerges merges interfaces by their dynamic types. When both
+// interfaces hold the same dynamic type, the values they hold are merged with
+// a function generated for that type the first time it is seen, with the same
+// options as the rest of the merge function. The merged value is stored into
+// the left interface; the values held in interfaces are never modified in
+// place, because they may be shared with copies of the interface.
+//
+// When the interfaces hold different dynamic types, mismatch decides what
+// happens to the left interface. A nil interface is treated as a missing map
+// key, as with WithTrees: a nil left interface adopts the right interface,
+// and a nil right interface leaves the left one as it is, such that merging
+// into a zero value (for example, an Accumulator's total) adopts what is
+// merged.
+//
+// Generating a function for a dynamic type happens while merging, and if a
+// dynamic type cannot be merged (for example, if it is a string), merging
+// panics. Fields within interfaces cannot be skipped or selected.
+//
+// GenReset and GenClone also dispatch on dynamic types: resetting keeps an
+// interface's dynamic type and resets what it holds, and cloning deep copies
+// what it holds. GenDiff cannot diff interfaces.
+func WithInterfaceMerges(mismatch MismatchPolicy) func(*Config) error {
+	return func(c *Config) error {
+		if mismatch > MismatchPanic {
+			return fmt.Errorf("unknown mismatch policy %v", mismatch)
+		}
+		c.interfaces = true
+		c.mismatch = mismatch
+		return nil
+	}
+}
+
+// WithInterfaceImpls registers the dynamic types that interfaces of type
+// iface are expected to hold, and merges interfaces as with
+// WithInterfaceMerges (keeping the left interface on mismatches unless
+// WithInterfaceMerges says otherwise). Functions for the registered types are
+// generated with the merge function, such that errors are returned by Gen and
+// merging never generates functions for these types. Interfaces holding the
+// same registered type are dispatched by a lookup of the interface's type
+// word.
+//
+// Interfaces that both hold the same type that is not registered follow the
+// unknown policy. Registering an interface type again replaces its prior
+// registration. For example, for a field of type any that holds either of
+// two payloads:
+//
+//     mergetyp.WithInterfaceImpls(
+//             reflect.TypeFor[any](),
+//             mergetyp.UnknownPanic,
+//             reflect.TypeFor[ClickPayload](),
+//             reflect.TypeFor[*ViewPayload](),
+//     )
+func WithInterfaceImpls(iface reflect.Type, unknown UnknownPolicy, impls ...reflect.Type) func(*Config) error {
+	return func(c *Config) error {
+		if iface == nil || iface.Kind() != reflect.Interface {
+			return fmt.Errorf("unable to register implementations for non-interface type %v", iface)
+		}
+		if unknown > UnknownPanic {
+			return fmt.Errorf("invalid unknown policy %v", unknown)
+		}
+		for _, impl := range impls {
+			if impl == nil || impl.Kind() == reflect.Interface || !impl.Implements(iface) {
+				return fmt.Errorf("type %v is not a concrete implementation of %v", impl, iface)
+			}
+		}
+		if c.impls == nil {
+			c.impls = make(map[reflect.Type]ifaceImpls)
+		}
+		c.impls[iface] = ifaceImpls{unknown, impls}
+		c.interfaces = true
+		return nil
+	}
+}
+
+// WithTrees merges dynamic trees, such as what encoding/json decodes into:
+// every map[string]any, []any, and any is merged by what it holds, without
+// requiring WithSlowerMapsUnsafely. This takes precedence over
+// WithInterfaceMerges for fields of type any.
+//
+// Trees are merged the same as typed values:
+//
+//   - maps are merged key by key, adopting values of keys only the right map
+//     has;
+//   - slices are merged as typed slices, keeping the longer slice on the left
+//     and merging the elements both have;
+//   - numbers and bools are merged with the strategy for the tree, as set with
+//     WithStrategy, the same as typed numbers and bools;
+//   - strings are merged with the strings strategy, which cannot be
+//     StrategySum; StrategyMin and StrategyMax compare strings
+//     lexicographically.
+//
+// A nil value is treated the same as a missing map key: the other side's
+// value is kept, so that merging into a reset or zero tree adopts what is
+// merged. Values that have different types are merged with the mismatch
+// policy. Other types in trees (for example, integers in trees built by hand)
+// are merged as with WithInterfaceMerges, using the types registered for any
+// with WithInterfaceImpls.
+//
+// GenReset clears maps and truncates slices in trees, and resets numbers,
+// bools, and strings held in an any to what merging starts from. GenClone
+// deep copies trees. GenDiff cannot diff trees.
+func WithTrees(strings Strategy, mismatch MismatchPolicy) func(*Config) error {
+	return func(c *Config) error {
+		if strings == StrategySum || strings > StrategyOverwrite {
+			return fmt.Errorf("invalid strategy %v for strings in trees", strings)
+		}
+		if mismatch > MismatchPanic {
+			return fmt.Errorf("unknown mismatch policy %v", mismatch)
+		}
+		c.trees = &treeConfig{strings, mismatch}
+		return nil
+	}
+}
+
+// WithLocking locks structs that contain a lock while merging them. Every
+// sync.Mutex and sync.RWMutex field is a lock, as is every field named by the
+// given paths, which must be struct fields whose address implements
+// sync.Locker. Paths are the same as in SkipField.
+//
+// A struct with locks is merged with the locks of both the left and right
+// value held, and the locks themselves are never merged. To avoid deadlocks
+// between concurrent merges of the same two values, the value at the lower
+// address is always locked first. Locks are not recursive: merging must not
+// be started while holding a lock that the merge will take.
+func WithLocking(paths ...string) func(*Config) error {
+	return func(c *Config) error {
+		c.locking = true
+		for _, path := range paths {
+			if err := c.addRule(actLock, 0, path); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
 // SkipField adds a field to be skipped in a struct for generated merge
 // function.
 //
@@ -85,9 +350,186 @@ func WithSlowerMapsUnsafely() func(*Config) error {
 //
 // and the call Gen(new(MyType), SkipField("Foo>bar>Baz")), the channel deep in
 // the struct will be ignored and only p will be merged.
+//
+// Fields promoted from embedded structs can be named directly, the same as
+// with Go selectors: if MyType embedded foobar rather than having the field
+// Foo, the path would be bar>Baz (foobar>bar>Baz also works). If two
+// embedded structs at the same depth provide the same field name, naming
+// that field is an error.
+//
+// Arrays and slices are passed through implicitly, as seen with bar above,
+// but any field can also be followed by selectors. [N] selects only element N
+// of an array or slice, and [*] selects every element. {key} selects a single
+// key of a map, where string keys are quoted and integer and bool keys are
+// bare; maps can only be passed through with a key selector. For example,
+//
+//     Shards[0]>Count
+//     Labels{"host"}
+//
+// skip Count only in the first shard, and skip merging the "host" key of the
+// Labels map entirely. A path can begin with a selector if the input type
+// itself is an array, slice, or map.
 func SkipField(field string) func(*Config) error {
+	return SkipFields(field)
+}
+
+// OnlyFields inverts SkipField: only the named fields are merged, and every
+// other field is left as it is in the left value. Fields are named with the
+// same > levels as SkipField.
+//
+// A field named without a deeper level is merged entirely. A field named with
+// a deeper level, such as Stats>Requests, is recursed into and again only
+// merges what is named at that deeper level. For example, with
+//
+//     type Stats struct {
+//         Requests int
+//         Errors   int
+//         Latency  int
+//     }
+//
+//     type MyType struct {
+//         Stats Stats
+//         Hits  int
+//     }
+//
+// the call Gen(new(MyType), OnlyFields("Stats>Requests", "Stats>Errors"))
+// will merge Requests and Errors, but not Latency nor Hits. Fields added to
+// these types later will not be merged until they are named.
+//
+// All named fields must exist, and skips take precedence over onlys.
+func OnlyFields(fields ...string) func(*Config) error {
+	return func(c *Config) error {
+		for _, field := range fields {
+			if err := c.addRule(actOnly, 0, field); err != nil {
+				return err
+			}
+		}
+		return nil
+	}
+}
+
+// Strategy is how two numbers (or times) are merged into one.
+type Strategy uint8
+
+const (
+	// StrategySum adds numbers and keeps any true bool. This is the
+	// default strategy. Times cannot be added, and are merged with
+	// StrategyLatest.
+	StrategySum Strategy = iota
+	// StrategyMin keeps the smaller of two numbers, and keeps true only
+	// if both bools are true.
+	StrategyMin
+	// StrategyMax keeps the larger of two numbers, and keeps any true
+	// bool.
+	StrategyMax
+	// StrategyKeep keeps the left number unless it is zero, in which case
+	// the right number is kept. For times, this keeps the first time
+	// seen.
+	StrategyKeep
+	// StrategyOverwrite keeps the right number unless it is zero, in
+	// which case the left number is kept. For times, this keeps the
+	// last time seen.
+	StrategyOverwrite
+
+	// StrategyEarliest keeps the earlier of two non-zero times.
+	StrategyEarliest = StrategyMin
+	// StrategyLatest keeps the later of two times.
+	StrategyLatest = StrategyMax
+)
+
+func (s Strategy) String() string {
+	switch s {
+	case StrategySum:
+		return "sum"
+	case StrategyMin:
+		return "min"
+	case StrategyMax:
+		return "max"
+	case StrategyKeep:
+		return "keep"
+	case StrategyOverwrite:
+		return "overwrite"
+	default:
+		return "Strategy(" + strconv.Itoa(int(s)) + ")"
+	}
+}
+
+// WithStrategy merges the field at path, and everything within it, with the
+// given strategy. Paths are the same as in SkipField; all fields must exist.
+// The empty path changes the strategy for the entire input type.
+//
+// For example, a histogram with an overflow bucket that tracks the largest
+// value seen could use
+//
+//     WithStrategy("Buckets[63]", StrategyMax)
+//
+// so that all buckets are summed except for the last. Complex numbers cannot
+// be merged with StrategyMin nor StrategyMax.
+func WithStrategy(path string, strategy Strategy) func(*Config) error {
+	return func(c *Config) error {
+		if strategy > StrategyOverwrite {
+			return fmt.Errorf("unknown strategy %v", strategy)
+		}
+		if path == "" {
+			c.strategy = strategy
+			return nil
+		}
+		return c.addRule(actStrategy, strategy, path)
+	}
+}
+
+// FieldFilter is a function that returns whether a struct field should be
+// merged. The path is the chain of field names from the input type to the
+// field, ending with the field's own name.
+type FieldFilter func(path []string, sf reflect.StructField) bool
+
+// WithFieldFilter adds a filter that is consulted for every struct field that
+// has not otherwise been skipped. If the filter returns false, the field is
+// skipped. If multiple filters are added, a field is only merged if every
+// filter returns true.
+//
+// Filters can skip fields by any rule, such as skipping unexported fields:
+//
+//     WithFieldFilter(func(_ []string, sf reflect.StructField) bool {
+//         return sf.PkgPath == ""
+//     })
+//
+// The path slice must not be retained. For recursive types, filters are
+// consulted only until a type repeats within its own path; the repeated type
+// is merged the same as its first occurrence.
+func WithFieldFilter(filter FieldFilter) func(*Config) error {
+	return func(c *Config) error {
+		c.filters = append(c.filters, filter)
+		return nil
+	}
+}
+
+// SkipTagged skips all struct fields whose key tag has the given value as its
+// name, that is, the tag value up to any comma. For example,
+// SkipTagged("json", "-") skips all fields that are tagged `json:"-"`.
+func SkipTagged(key, value string) func(*Config) error {
+	return WithFieldFilter(func(_ []string, sf reflect.StructField) bool {
+		name := sf.Tag.Get(key)
+		if idx := strings.IndexByte(name, ','); idx != -1 {
+			name = name[:idx]
+		}
+		return name != value
+	})
+}
+
+// WithTagNames addresses fields in paths (for SkipField, OnlyFields, and the
+// path given to field filters) by the name in their key tag rather than by
+// their Go field name. Fields that do not have a name in the tag, or that
+// are tagged "-", continue to be addressed by their Go field name.
+//
+// For example, with WithTagNames("json"), the field
+//
+//     RequestCount int `json:"request_count,omitempty"`
+//
+// is skipped with SkipField("request_count").
+func WithTagNames(key string) func(*Config) error {
 	return func(c *Config) error {
-		c.skips = append(c.skips, field)
+		c.nameTag = key
 		return nil
 	}
 }
@@ -102,8 +544,8 @@ func SkipField(field string) func(*Config) error {
 // returned function will panic if used on other types.
 //
 // Some types cannot be merged: interfaces in structs cannot be merged (because
-// there is no type behind it), and channels, functions, strings, and unsafe
-// pointers cannot be merged.
+// there is no type behind it) unless WithInterfaceMerges is used, and
+// channels, functions, strings, and unsafe pointers cannot be merged.
 //
 // Maps can be merged, but you have to opt into merging maps. The returned
 // closure's speed comes from using unsafe.Pointer internally and never using
@@ -118,61 +560,116 @@ func SkipField(field string) func(*Config) error {
 // that has fields that point to other fields, the other fields will be merged
 // twice (once for the direct field, once for the reference).
 //
-// Bool fields are merged such that "true" is always kept.
+// Numbers are summed and bool fields are merged such that "true" is always
+// kept. WithStrategy can change this per field.
+//
+// A time.Time is merged as a whole rather than as a struct: by default, the
+// later of two times is kept, and StrategyEarliest keeps the earlier. A zero
+// time never replaces a non-zero time, so that merging into a zero value
+// records the first time seen with StrategyEarliest or StrategyKeep and the
+// last time seen with StrategyLatest or StrategyOverwrite.
+//
+// Numbers from math/big (big.Int, big.Float, and big.Rat) are merged with
+// their methods rather than as structs: summing uses Add, and StrategyMin and
+// StrategyMax compare with Cmp. Pointers to them are merged as any other
+// pointer.
+//
+// Types that cannot be merged otherwise can be merged with a function
+// registered with WithTypeMerger or WithMergerFor.
+//
+// Types from sync/atomic, such as atomic.Int64 and atomic.Bool, are merged
+// with their atomic operations, so that merging into them is safe while they
+// are used concurrently. What an atomic.Pointer points to is merged as with
+// any other pointer. An atomic.Value cannot be merged.
 //
 // This function takes an arbitrary number of options to configure merging
 // behavior. These options control enabling merging maps, skipping fields,
 // etc.
 func Gen(i interface{}, options ...func(*Config) error) (func(l, r interface{}), error) {
+	g, v, err := newGenerator(i, options)
+	if err != nil {
+		return nil, err
+	}
+	f, err := g.gen(v)
+	if err != nil {
+		return nil, err
+	}
+
+	check := typeChecker(i, "merge")
+	if f == nil { // nothing inside to merge
+		return func(l, r interface{}) {
+			check(l)
+			check(r)
+		}, nil
+	}
+
+	return func(l, r interface{}) {
+		lp, rp := check(l), check(r)
+		f(lp, rp)
+	}, nil
+}
+
+// typeChecker returns a function that returns the data pointer of an
+// interface, panicking if the interface is not of the same type as i. The
+// panic says that a what function was misused.
+func typeChecker(i interface{}, what string) func(interface{}) unsafe.Pointer {
+	// _just_ to be sure that we allow the input value to be recycled,
+	// we create our own zero type for saving the type pointer.
+	z := reflect.Zero(reflect.ValueOf(i).Type()).Interface()
+	iw := (*ifaceWords)(unsafe.Pointer(&z))
+	return func(v interface{}) unsafe.Pointer {
+		vw := (*ifaceWords)(unsafe.Pointer(&v))
+		if vw.typ != iw.typ {
+			panic(what + " function used on type it was not generated for")
+		}
+		return vw.data
+	}
+}
+
+// newGenerator applies all options and returns the generator for the value
+// that i points to.
+func newGenerator(i interface{}, options []func(*Config) error) (*generator, reflect.Value, error) {
 	var c Config
 	for _, option := range options {
 		if err := option(&c); err != nil {
-			return nil, err
+			return nil, reflect.Value{}, err
 		}
 	}
 
 	v := reflect.ValueOf(i)
 	if v.Kind() != reflect.Ptr {
-		return nil, errors.New("merge functions can only be generated for pointer types")
+		return nil, v, errors.New("merge functions can only be generated for pointer types")
 	}
 	v = reflect.Indirect(v)
 	if v.Kind() == reflect.Ptr {
-		return nil, errors.New("merge functions can only be generated for single-pointer-indirection types")
+		return nil, v, errors.New("merge functions can only be generated for single-pointer-indirection types")
+	}
+	for _, r := range c.rules {
+		if r.typ != nil && r.typ != v.Type() {
+			return nil, v, fmt.Errorf("%s selector %s for type %v used to generate for type %v", r.act, r.path, r.typ, v.Type())
+		}
 	}
 
-	f, err := (&generator{
+	return &generator{
 		structFs: make(map[string]*mergeF),
+		cloneFs:  make(map[reflect.Type]*mergeF),
 		useMap:   c.unsafeMap,
-		skips:    c.skips,
-	}).gen(v)
-	if err != nil {
-		return nil, err
-	}
+		atomic:   c.atomic,
+		locking:  c.locking,
+		mergers:  c.mergers,
+		rules:    c.rules,
+		strategy: c.strategy,
+		filters:  c.filters,
+		nameTag:  c.nameTag,
 
-	// _just_ to be sure that we allow the input value to be recycled,
-	// we create our own zero type for saving the type pointer.
-	z := reflect.Zero(reflect.ValueOf(i).Type()).Interface()
-	iw := (*ifaceWords)(unsafe.Pointer(&z))
-	check := func(l, r interface{}) (unsafe.Pointer, unsafe.Pointer) {
-		il := (*ifaceWords)(unsafe.Pointer(&l))
-		ir := (*ifaceWords)(unsafe.Pointer(&r))
+		resetters: c.resetters,
 
-		if il.typ != iw.typ || ir.typ != iw.typ {
-			panic("merge function used on type it was not generated for")
-		}
-		return il.data, ir.data
-	}
-
-	if f == nil { // nothing inside to merge
-		return func(l, r interface{}) {
-			check(l, r)
-		}, nil
-	}
-
-	return func(l, r interface{}) {
-		lp, rp := check(l, r)
-		f(lp, rp)
-	}, nil
+		interfaces: c.interfaces,
+		mismatch:   c.mismatch,
+		impls:      c.impls,
+		trees:      c.trees,
+		lazy:       new(sync.Mutex),
+	}, v, nil
 }
 
 // MustGen is like Gen but panics if the merge function cannot be generated.
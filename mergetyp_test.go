@@ -2,6 +2,7 @@ package mergetyp
 
 import (
 	"reflect"
+	"strings"
 	"testing"
 )
 
@@ -172,3 +173,194 @@ func TestGen(t *testing.T) {
 		t.Error("not deep equal")
 	}
 }
+
+type onlyStats struct {
+	Requests int
+	Errors   int
+	Latency  int
+}
+
+type onlyType struct {
+	Stats  onlyStats
+	Hits   int
+	Shards []onlyStats
+	Ptr    *onlyStats
+}
+
+func TestOnlyFields(t *testing.T) {
+	l := onlyType{
+		Stats:  onlyStats{1, 1, 1},
+		Hits:   1,
+		Shards: []onlyStats{{1, 1, 1}},
+		Ptr:    &onlyStats{1, 1, 1},
+	}
+	r := onlyType{
+		Stats:  onlyStats{2, 2, 2},
+		Hits:   2,
+		Shards: []onlyStats{{2, 2, 2}},
+		Ptr:    &onlyStats{2, 2, 2},
+	}
+
+	f, err := Gen(&l,
+		OnlyFields("Stats>Requests", "Stats>Errors", "Shards", "Ptr>Latency"),
+		SkipField("Shards>Errors"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f(&l, &r)
+
+	exp := onlyType{
+		Stats:  onlyStats{3, 3, 1},
+		Hits:   1,
+		Shards: []onlyStats{{3, 1, 3}},
+		Ptr:    &onlyStats{1, 1, 3},
+	}
+	if !reflect.DeepEqual(l, exp) {
+		t.Errorf("got %+v != exp %+v", l, exp)
+	}
+
+	for _, only := range []string{
+		"Missing",
+		"Stats>Missing",
+		"Hits>Missing",
+	} {
+		if _, err := Gen(&l, OnlyFields(only)); err == nil {
+			t.Errorf("expected error for only %q", only)
+		}
+	}
+}
+
+type filterInner struct {
+	N     int
+	skip  int
+	Extra int `merge:"-"`
+}
+
+type filterType struct {
+	A    filterInner
+	B    []filterInner
+	next *filterType
+}
+
+func TestWithFieldFilter(t *testing.T) {
+	var paths []string
+	f, err := Gen(new(filterType),
+		WithFieldFilter(func(path []string, sf reflect.StructField) bool {
+			paths = append(paths, strings.Join(path, ">"))
+			return sf.Tag.Get("merge") != "-"
+		}),
+		WithFieldFilter(func(path []string, sf reflect.StructField) bool {
+			return sf.Name != "skip"
+		}),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expPaths := []string{
+		"A", "A>N", "A>skip", "A>Extra",
+		"B", "B>N", "B>skip", "B>Extra",
+		"next",
+	}
+	if !reflect.DeepEqual(paths, expPaths) {
+		t.Errorf("got paths %v != exp %v", paths, expPaths)
+	}
+
+	l := filterType{
+		A:    filterInner{1, 1, 1},
+		B:    []filterInner{{1, 1, 1}},
+		next: &filterType{A: filterInner{1, 1, 1}},
+	}
+	r := filterType{
+		A:    filterInner{2, 2, 2},
+		B:    []filterInner{{2, 2, 2}},
+		next: &filterType{A: filterInner{2, 2, 2}},
+	}
+	f(&l, &r)
+
+	exp := filterType{
+		A:    filterInner{3, 1, 1},
+		B:    []filterInner{{3, 1, 1}},
+		next: &filterType{A: filterInner{3, 1, 1}},
+	}
+	if !reflect.DeepEqual(l, exp) {
+		t.Errorf("got %+v != exp %+v", l, exp)
+	}
+}
+
+type taggedInner struct {
+	Count   int `json:"count,omitempty"`
+	Scratch int `json:"-"`
+	Other   int `json:",omitempty"`
+}
+
+type taggedType struct {
+	Inner taggedInner `json:"inner"`
+	Seen  int         `json:"seen"`
+}
+
+func TestTags(t *testing.T) {
+	l := taggedType{taggedInner{1, 1, 1}, 1}
+	r := taggedType{taggedInner{2, 2, 2}, 2}
+
+	f, err := Gen(&l,
+		SkipTagged("json", "-"),
+		WithTagNames("json"),
+		SkipFields("seen", "inner>Other"),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f(&l, &r)
+
+	exp := taggedType{taggedInner{3, 1, 1}, 1}
+	if !reflect.DeepEqual(l, exp) {
+		t.Errorf("got %+v != exp %+v", l, exp)
+	}
+
+	if _, err := Gen(&l, WithTagNames("json"), SkipField("Seen")); err == nil {
+		t.Error("expected error skipping field by Go name when it has a tag name")
+	}
+}
+
+type promoInner struct {
+	Deep int
+}
+
+type PromoA struct {
+	A    int
+	Same int
+	*promoInner
+}
+
+type PromoB struct {
+	B    int
+	Same int
+}
+
+type promoType struct {
+	PromoA
+	PromoB
+	Own int
+}
+
+func TestPromotedFields(t *testing.T) {
+	l := promoType{PromoA{1, 1, &promoInner{1}}, PromoB{1, 1}, 1}
+	r := promoType{PromoA{2, 2, &promoInner{2}}, PromoB{2, 2}, 2}
+
+	f, err := Gen(&l, SkipFields("A", "PromoB>Same", "Deep"), OnlyFields("A", "B", "Deep", "PromoA>Same", "PromoB"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f(&l, &r)
+
+	exp := promoType{PromoA{1, 3, &promoInner{1}}, PromoB{3, 1}, 1}
+	if !reflect.DeepEqual(l, exp) {
+		t.Errorf("got %+v != exp %+v", l, exp)
+	}
+
+	if _, err := Gen(&l, SkipField("Same")); err == nil || !strings.Contains(err.Error(), "ambiguous") {
+		t.Errorf("expected ambiguous error, got %v", err)
+	}
+}
@@ -0,0 +1,90 @@
+// Package mergetyptest provides test helpers for types that are merged with
+// mergetyp.
+//
+// AssertPlan compares a type's merge plan against a golden file that is
+// checked in next to the test. When a field is added to the type, the test
+// fails until the golden file is updated, forcing whoever added the field to
+// acknowledge how it will be merged. Golden files are (re)written by running
+// the tests with the -mergetyp.update flag:
+//
+//     go test ./... -mergetyp.update
+//
+// An -update flag defined by the package being tested for its own golden
+// files does not rewrite plan golden files: plan changes are only accepted
+// explicitly.
+package mergetyptest
+
+import (
+	"flag"
+	"os"
+	"path/filepath"
+	"strings"
+	"testing"
+
+	"github.com/twmb/go-mergetyp"
+)
+
+// The flag is namespaced so that it does not collide with an -update flag
+// defined by the package being tested.
+var update = flag.Bool("mergetyp.update", false, "rewrite mergetyptest golden plan files")
+
+// AssertPlan fails t if the merge plan for i with the given options does not
+// match the text plan in the golden file. If the -mergetyp.update flag is set,
+// the golden file is written instead.
+//
+// The plan is serialized with PlanNode.WriteText.
+func AssertPlan(t testing.TB, i interface{}, golden string, options ...func(*mergetyp.Config) error) {
+	t.Helper()
+
+	plan, err := mergetyp.Plan(i, options...)
+	if err != nil {
+		t.Fatalf("unable to plan %T: %v", i, err)
+		return
+	}
+	got := plan.String()
+
+	if *update {
+		if err := os.MkdirAll(filepath.Dir(golden), 0o755); err != nil {
+			t.Fatalf("unable to create golden directory: %v", err)
+			return
+		}
+		if err := os.WriteFile(golden, []byte(got), 0o644); err != nil {
+			t.Fatalf("unable to write golden file: %v", err)
+		}
+		return
+	}
+
+	raw, err := os.ReadFile(golden)
+	if err != nil {
+		t.Fatalf("unable to read golden file (run with -mergetyp.update to create it): %v", err)
+		return
+	}
+	if exp := string(raw); got != exp {
+		t.Errorf("merge plan for %T does not match %s (run with -mergetyp.update to accept):\n%s", i, golden, diff(exp, got))
+	}
+}
+
+// diff returns the lines that differ between exp and got, prefixed with - and
+// + respectively. Lines common to the start and end of both are elided.
+func diff(exp, got string) string {
+	el := strings.Split(strings.TrimSuffix(exp, "\n"), "\n")
+	gl := strings.Split(strings.TrimSuffix(got, "\n"), "\n")
+
+	var pre int
+	for pre < len(el) && pre < len(gl) && el[pre] == gl[pre] {
+		pre++
+	}
+	var suf int
+	for suf < len(el)-pre && suf < len(gl)-pre && el[len(el)-1-suf] == gl[len(gl)-1-suf] {
+		suf++
+	}
+
+	var sb strings.Builder
+	for _, l := range el[pre : len(el)-suf] {
+		sb.WriteString("- " + l + "\n")
+	}
+	for _, l := range gl[pre : len(gl)-suf] {
+		sb.WriteString("+ " + l + "\n")
+	}
+	return sb.String()
+}
@@ -0,0 +1,76 @@
+package mergetyptest
+
+import (
+	"flag"
+	"fmt"
+	"testing"
+
+	"github.com/twmb/go-mergetyp"
+)
+
+// Packages with other golden files commonly define their own -update flag,
+// which must not collide with ours.
+var _ = flag.Bool("update", false, "rewrite golden files")
+
+type stats struct {
+	Requests int64
+	Errors   int64
+	Name     string
+}
+
+// recorder records failures rather than failing the test.
+type recorder struct {
+	testing.TB
+	msgs []string
+}
+
+func (r *recorder) Helper() {}
+
+func (r *recorder) Errorf(format string, args ...interface{}) {
+	r.msgs = append(r.msgs, fmt.Sprintf(format, args...))
+}
+
+func (r *recorder) Fatalf(format string, args ...interface{}) {
+	r.msgs = append(r.msgs, fmt.Sprintf(format, args...))
+}
+
+func TestAssertPlan(t *testing.T) {
+	AssertPlan(t, new(stats), "testdata/stats.plan", mergetyp.SkipField("Name"))
+	if *update {
+		return // the failure cases below would overwrite the golden file
+	}
+
+	r := &recorder{TB: t}
+	AssertPlan(r, new(stats), "testdata/stats.plan", mergetyp.SkipField("Name"), mergetyp.WithStrategy("Errors", mergetyp.StrategyMax))
+	if len(r.msgs) != 1 {
+		t.Fatalf("got %d failures, expected 1", len(r.msgs))
+	}
+	exp := `merge plan for *mergetyptest.stats does not match testdata/stats.plan (run with -mergetyp.update to accept):
+-   Errors int64: merge sum (fast)
++   Errors int64: merge max
+`
+	if r.msgs[0] != exp {
+		t.Errorf("got failure\n%s\nexpected\n%s", r.msgs[0], exp)
+	}
+
+	r = &recorder{TB: t}
+	AssertPlan(r, new(stats), "testdata/missing.plan")
+	if len(r.msgs) != 1 {
+		t.Errorf("got %d failures for a missing golden file, expected 1", len(r.msgs))
+	}
+}
+
+func TestOwnUpdateFlag(t *testing.T) {
+	if *update {
+		t.Skip("already updating")
+	}
+	// The package's own -update flag must not accept plan changes.
+	flag.Set("update", "true")
+	defer flag.Set("update", "false")
+
+	r := &recorder{TB: t}
+	AssertPlan(r, new(stats), "testdata/stats.plan", mergetyp.SkipField("Name"), mergetyp.WithStrategy("Errors", mergetyp.StrategyMax))
+	if len(r.msgs) != 1 {
+		t.Errorf("got %d failures with -update set, expected 1", len(r.msgs))
+	}
+}
@@ -0,0 +1,457 @@
+package mergetyp
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"sort"
+	"strconv"
+	"strings"
+
+	"github.com/twmb/go-mergetyp/internal/fieldpath"
+)
+
+// This file contains the logic to split the paths given to options such as
+// SkipField level by level as we recurse. Paths themselves are parsed in
+// internal/fieldpath.
+
+// step is one level of a path.
+type step struct {
+	fieldpath.Step
+	exact bool // whether a field matches by field index (in Index), not name
+}
+
+// matches returns whether a field step matches the i'th field of a struct,
+// which is addressed by name.
+func (s step) matches(i int, name string) bool {
+	if s.exact {
+		return s.Index == i
+	}
+	return s.Name == name
+}
+
+type action uint8
+
+const (
+	actSkip action = iota
+	actOnly
+	actStrategy
+	actLock
+)
+
+func (a action) String() string {
+	switch a {
+	case actSkip:
+		return "skip"
+	case actOnly:
+		return "only"
+	case actLock:
+		return "lock"
+	default:
+		return "strategy"
+	}
+}
+
+// rule is a parsed path and what to do at the end of it. As we recurse,
+// steps are consumed from the front.
+type rule struct {
+	act      action
+	strategy Strategy
+	steps    []step
+	path     string       // the original path, for errors
+	typ      reflect.Type // if non-nil, the only type this rule applies to
+}
+
+// next returns the rule for the level below the current step.
+func (r rule) next() rule {
+	r.steps = r.steps[1:]
+	return r
+}
+
+// parsePath parses a path into its steps.
+func parsePath(path string) ([]step, error) {
+	parsed, err := fieldpath.Parse(path)
+	if err != nil {
+		return nil, err
+	}
+	steps := make([]step, 0, len(parsed))
+	for _, s := range parsed {
+		steps = append(steps, step{Step: s})
+	}
+	return steps, nil
+}
+
+// parseKey parses a key literal from a path into a value of the map key type
+// kt. Strings must be quoted; integers and bools are bare.
+func parseKey(lit string, kt reflect.Type) (interface{}, error) {
+	if !fieldpath.KeyKind(kt.Kind()) {
+		return nil, fmt.Errorf("unable to select map keys of kind %v", kt.Kind())
+	}
+	var bits int
+	if kt.Kind() != reflect.String && kt.Kind() != reflect.Bool {
+		bits = kt.Bits()
+	}
+	key, ok := fieldpath.ParseKey(lit, kt.Kind(), bits)
+	if !ok {
+		return nil, fmt.Errorf("invalid key {%s} for map key type %v", lit, kt)
+	}
+	kv := reflect.New(kt).Elem()
+	switch key := key.(type) {
+	case string:
+		kv.SetString(key)
+	case int64:
+		kv.SetInt(key)
+	case uint64:
+		kv.SetUint(key)
+	case bool:
+		kv.SetBool(key)
+	}
+	return kv.Interface(), nil
+}
+
+// sub returns the generator for something below the current level: a struct
+// field, an array or slice element, or a map value. The matched rules are the
+// rules whose current step selects the child; through are rules that pass
+// through this level without being consumed (struct fields through an array
+// or slice). If the child is skipped, the generator has its skip reason set.
+//
+// Skips take precedence over everything. If any rule at our level is an
+// only, the child is skipped unless it is selected entirely or an only
+// continues below it. A child selected entirely is merged with no more
+// selection below it.
+func (g *generator) sub(matched, through []rule) *generator {
+	c := *g
+	c.rules = nil
+	c.plan = nil
+	c.lock = false
+
+	var selecting bool
+	for _, r := range g.rules {
+		if r.act == actOnly {
+			selecting = true
+			break
+		}
+	}
+
+	// A lock is never merged, even if it is also skipped, so we check
+	// for locks before anything else.
+	for _, r := range matched {
+		if r.act == actLock && len(r.steps) == 1 {
+			c.lock = true
+		}
+	}
+
+	var all bool
+	for _, r := range matched {
+		if len(r.steps) > 1 {
+			c.rules = append(c.rules, r.next())
+			continue
+		}
+		switch r.act {
+		case actSkip:
+			c.skip = fmt.Sprintf("skipped by path %q", r.path)
+			return &c
+		case actOnly:
+			all = true
+		case actStrategy:
+			c.strategy = r.strategy
+		}
+	}
+	c.rules = append(c.rules, through...)
+
+	var onlyBelow bool
+	for i := 0; i < len(c.rules); i++ {
+		if c.rules[i].act != actOnly {
+			continue
+		}
+		if all {
+			c.rules = append(c.rules[:i], c.rules[i+1:]...)
+			i--
+			continue
+		}
+		onlyBelow = true
+	}
+	if selecting && !all && !onlyBelow {
+		c.skip = "not selected by only paths"
+	}
+	return &c
+}
+
+// splitIndices splits our rules for the elements of an array or slice of
+// length n (n is -1 for slices). This returns the generator for elements that
+// are not selected by index and the generators for elements that are, if any
+// are.
+func (g *generator) splitIndices(n int) (*generator, map[int]*generator, error) {
+	var through, anys []rule
+	var indices []int
+	indexed := make(map[int][]rule)
+	for _, r := range g.rules {
+		switch s := r.steps[0]; s.Kind {
+		case fieldpath.Field:
+			through = append(through, r)
+		case fieldpath.Any:
+			anys = append(anys, r)
+		case fieldpath.Index:
+			if n >= 0 && s.Index >= n {
+				return nil, nil, fmt.Errorf("did not see index %v of %s path %q: array has length %d", s, r.act, r.path, n)
+			}
+			if _, exists := indexed[s.Index]; !exists {
+				indices = append(indices, s.Index)
+			}
+			indexed[s.Index] = append(indexed[s.Index], r)
+		default:
+			return nil, nil, fmt.Errorf("invalid %s path %q: unable to use key selector %v on an array or slice", r.act, r.path, s)
+		}
+	}
+
+	def := g.sub(anys, through)
+	def.plan = g.plan.child("[*]")
+	var specials map[int]*generator
+	if len(indexed) > 0 {
+		specials = make(map[int]*generator, len(indexed))
+	}
+	sort.Ints(indices)
+	for _, index := range indices {
+		c := g.sub(append(anys[:len(anys):len(anys)], indexed[index]...), through)
+		c.plan = g.plan.child("[" + strconv.Itoa(index) + "]")
+		specials[index] = c
+	}
+	return def, specials, nil
+}
+
+// splitKeys splits our rules for the values of a map with key type kt. This
+// returns the generator for values whose keys are not selected and the
+// generators for the keys that are, if any are.
+func (g *generator) splitKeys(kt reflect.Type) (*generator, map[interface{}]*generator, error) {
+	var keys []interface{}
+	keyed := make(map[interface{}][]rule)
+	for _, r := range g.rules {
+		s := r.steps[0]
+		if s.Kind != fieldpath.Key {
+			return nil, nil, fmt.Errorf("invalid %s path %q: unable to use %v on a map, select a key with {key}", r.act, r.path, s)
+		}
+		k, err := parseKey(s.Name, kt)
+		if err != nil {
+			return nil, nil, fmt.Errorf("invalid %s path %q: %v", r.act, r.path, err)
+		}
+		if _, exists := keyed[k]; !exists {
+			keys = append(keys, k)
+		}
+		keyed[k] = append(keyed[k], r)
+	}
+
+	def := g.sub(nil, nil)
+	def.plan = g.plan.child("{*}")
+	var specials map[interface{}]*generator
+	if len(keyed) > 0 {
+		specials = make(map[interface{}]*generator, len(keyed))
+	}
+	for _, k := range keys {
+		rules := keyed[k]
+		c := g.sub(rules, nil)
+		c.plan = g.plan.child(rules[0].steps[0].String())
+		specials[k] = c
+	}
+	return def, specials, nil
+}
+
+// promote rewrites rules whose first field is not a field of t but is a field
+// promoted from an embedded struct to go through the embedded fields. Rules
+// whose first field cannot be found are left as is.
+func (g *generator) promote(t reflect.Type, rules []rule) ([]rule, error) {
+	var rewritten []rule
+	for i, r := range rules {
+		if r.steps[0].Kind != fieldpath.Field {
+			return nil, fmt.Errorf("invalid %s path %q: unable to use selector %v on a struct", r.act, r.path, r.steps[0])
+		}
+		if r.steps[0].exact {
+			continue
+		}
+		chain, err := g.promoted(t, r.steps[0].Name)
+		if err != nil {
+			return nil, err
+		}
+		if len(chain) < 2 {
+			continue
+		}
+		if rewritten == nil {
+			rewritten = append([]rule(nil), rules...)
+		}
+		steps := make([]step, 0, len(chain)+len(r.steps)-1)
+		for _, name := range chain {
+			steps = append(steps, step{Step: fieldpath.Step{Kind: fieldpath.Field, Name: name}})
+		}
+		rewritten[i].steps = append(steps, r.steps[1:]...)
+	}
+	if rewritten == nil {
+		return rules, nil
+	}
+	return rewritten, nil
+}
+
+// promoted returns the chain of field names to reach name in t, following
+// Go's selector rules: the shallowest field wins, and two fields at the same
+// shallowest depth are ambiguous. This returns a nil chain if the name does
+// not exist.
+func (g *generator) promoted(t reflect.Type, name string) ([]string, error) {
+	type embedded struct {
+		t     reflect.Type
+		chain []string
+	}
+
+	current := []embedded{{t: t}}
+	seen := map[reflect.Type]bool{t: true}
+	for len(current) > 0 {
+		var found [][]string
+		var next []embedded
+		for _, e := range current {
+			for i := 0; i < e.t.NumField(); i++ {
+				sf := e.t.Field(i)
+				fname := g.fieldName(sf)
+				chain := append(e.chain[:len(e.chain):len(e.chain)], fname)
+				if fname == name {
+					found = append(found, chain)
+				}
+				if !sf.Anonymous {
+					continue
+				}
+				ft := sf.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if ft.Kind() == reflect.Struct && !seen[ft] {
+					next = append(next, embedded{ft, chain})
+				}
+			}
+		}
+
+		switch len(found) {
+		case 0:
+		case 1:
+			return found[0], nil
+		default:
+			return nil, fmt.Errorf("ambiguous field %q: promoted through both %s and %s",
+				name, strings.Join(found[0], ">"), strings.Join(found[1], ">"))
+		}
+
+		// Types embedded at this depth are only skipped at deeper
+		// depths, so that embedding one type twice at the same depth
+		// is still ambiguous.
+		for _, e := range next {
+			seen[e.t] = true
+		}
+		current = next
+	}
+	return nil, nil
+}
+
+// unseen returns an error for all rules that were not used at the level of
+// struct t, that is, all rules naming a field that does not exist. Each
+// missing field says where it was missing and what field may have been meant.
+func (g *generator) unseen(t reflect.Type, rules []rule, used []bool) error {
+	var missing []string
+	var names []string
+	for i, r := range rules {
+		if used[i] {
+			continue
+		}
+		if names == nil {
+			names = g.fieldNames(t)
+		}
+
+		var msg string
+		switch {
+		case r.act == actSkip && len(r.steps) == 1:
+			msg = "did not see all fields that we were required to skip"
+		case r.act == actSkip:
+			msg = "did not see all fields names for next level skips"
+		case r.act == actOnly:
+			msg = "did not see all fields that we were required to merge"
+		case r.act == actLock:
+			msg = "did not see all fields that we were required to lock with"
+		default:
+			msg = "did not see all fields that we were required to apply strategies to"
+		}
+
+		at := "at the top level"
+		if len(g.path) > 0 {
+			at = "at " + strings.Join(g.path, ">")
+		}
+		msg = fmt.Sprintf("%s: missing %s of %q in %v %s", msg, r.steps[0], r.path, t, at)
+		if suggestion := suggest(r.steps[0].Name, names); suggestion != "" {
+			msg += fmt.Sprintf(" (did you mean %s?)", suggestion)
+		}
+		missing = append(missing, msg)
+	}
+	if len(missing) == 0 {
+		return nil
+	}
+	return errors.New(strings.Join(missing, "; "))
+}
+
+// fieldNames returns the names of all fields that can be named in t,
+// including fields promoted from embedded structs.
+func (g *generator) fieldNames(t reflect.Type) []string {
+	names := []string{}
+	seen := map[reflect.Type]bool{t: true}
+	for current := []reflect.Type{t}; len(current) > 0; {
+		var next []reflect.Type
+		for _, et := range current {
+			for i := 0; i < et.NumField(); i++ {
+				sf := et.Field(i)
+				names = append(names, g.fieldName(sf))
+				ft := sf.Type
+				if ft.Kind() == reflect.Ptr {
+					ft = ft.Elem()
+				}
+				if sf.Anonymous && ft.Kind() == reflect.Struct && !seen[ft] {
+					seen[ft] = true
+					next = append(next, ft)
+				}
+			}
+		}
+		current = next
+	}
+	return names
+}
+
+// suggest returns the name closest to the mistyped name, preferring names
+// that only differ by case, or the empty string if no name is close.
+func suggest(mistyped string, names []string) string {
+	var best string
+	bestDist := -1
+	for _, name := range names {
+		if strings.EqualFold(name, mistyped) {
+			return name
+		}
+		dist := levenshtein(strings.ToLower(mistyped), strings.ToLower(name))
+		if bestDist == -1 || dist < bestDist {
+			best, bestDist = name, dist
+		}
+	}
+	if bestDist == -1 || bestDist > 2 && bestDist > len(mistyped)/3 {
+		return ""
+	}
+	return best
+}
+
+// levenshtein returns the edit distance between a and b.
+func levenshtein(a, b string) int {
+	prev := make([]int, len(b)+1)
+	cur := make([]int, len(b)+1)
+	for j := range prev {
+		prev[j] = j
+	}
+	for i := 1; i <= len(a); i++ {
+		cur[0] = i
+		for j := 1; j <= len(b); j++ {
+			cost := 1
+			if a[i-1] == b[j-1] {
+				cost = 0
+			}
+			cur[j] = min(prev[j]+1, cur[j-1]+1, prev[j-1]+cost)
+		}
+		prev, cur = cur, prev
+	}
+	return prev[len(b)]
+}
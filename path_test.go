@@ -0,0 +1,143 @@
+package mergetyp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type selShard struct {
+	Count int
+	Max   int
+}
+
+type selType struct {
+	Buckets [4]uint64
+	Shards  []selShard
+	Labels  map[string]selShard
+	Other   int
+}
+
+func TestSelectorsAndStrategies(t *testing.T) {
+	l := selType{
+		Buckets: [4]uint64{1, 1, 1, 5},
+		Shards:  []selShard{{1, 1}, {1, 1}},
+		Labels:  map[string]selShard{"host": {1, 1}, "zone": {1, 1}},
+		Other:   3,
+	}
+	r := selType{
+		Buckets: [4]uint64{2, 2, 2, 2},
+		Shards:  []selShard{{2, 2}, {2, 2}},
+		Labels:  map[string]selShard{"host": {2, 2}, "zone": {2, 2}, "new": {2, 2}, "skip": {2, 2}},
+		Other:   2,
+	}
+
+	f, err := Gen(&l,
+		WithStrategy("Buckets[3]", StrategyMax),
+		WithStrategy("Shards[*]>Max", StrategyMax),
+		SkipField("Shards[1]>Count"),
+		WithStrategy(`Labels{"zone"}`, StrategyMin),
+		SkipField(`Labels{"skip"}`),
+		WithStrategy("Other", StrategyMin),
+		WithSlowerMapsUnsafely(),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f(&l, &r)
+
+	exp := selType{
+		Buckets: [4]uint64{3, 3, 3, 5},
+		Shards:  []selShard{{3, 2}, {1, 2}},
+		Labels:  map[string]selShard{"host": {3, 3}, "zone": {1, 1}, "new": {2, 2}},
+		Other:   2,
+	}
+	if !reflect.DeepEqual(l, exp) {
+		t.Errorf("got %+v != exp %+v", l, exp)
+	}
+
+	l = selType{Buckets: [4]uint64{1, 1, 1, 1}, Shards: []selShard{{1, 1}, {1, 1}}}
+	r = selType{Buckets: [4]uint64{2, 2, 2, 2}, Shards: []selShard{{2, 2}, {2, 2}}}
+	f, err = Gen(&l, OnlyFields("Buckets[2]", "Shards[0]>Count"))
+	if err != nil {
+		t.Fatal(err)
+	}
+	f(&l, &r)
+	exp = selType{Buckets: [4]uint64{1, 1, 3, 1}, Shards: []selShard{{3, 1}, {1, 1}}}
+	if !reflect.DeepEqual(l, exp) {
+		t.Errorf("got %+v != exp %+v", l, exp)
+	}
+
+	for _, opt := range []func(*Config) error{
+		SkipField("Buckets[4]"),
+		SkipField("Labels>Count"),
+		SkipField("Labels{host}"),
+		SkipField("Other[0]"),
+		WithStrategy("Missing", StrategyMax),
+		WithStrategy("Other", Strategy(100)),
+	} {
+		if _, err := Gen(&l, opt, WithSlowerMapsUnsafely()); err == nil {
+			t.Error("expected error")
+		}
+	}
+}
+
+type suggestInner struct {
+	Baz     int
+	Counter int
+}
+
+type suggestType struct {
+	Foo suggestInner
+	Bar int
+}
+
+func TestUnseenSuggestions(t *testing.T) {
+	_, err := Gen(new(suggestType), SkipFields("foo>baz", "Qux"))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, exp := range []string{
+		`missing foo of "foo>baz" in mergetyp.suggestType at the top level (did you mean Foo?)`,
+		`missing Qux of "Qux" in mergetyp.suggestType at the top level`,
+	} {
+		if !strings.Contains(err.Error(), exp) {
+			t.Errorf("error %q does not contain %q", err, exp)
+		}
+	}
+
+	_, err = Gen(new(suggestType), SkipFields("Foo>Countr", "Foo>Zzzzzz"))
+	if err == nil {
+		t.Fatal("expected error")
+	}
+	for _, exp := range []string{
+		`missing Countr of "Foo>Countr" in mergetyp.suggestInner at Foo (did you mean Counter?)`,
+		`missing Zzzzzz of "Foo>Zzzzzz" in mergetyp.suggestInner at Foo;`,
+	} {
+		if !strings.Contains(err.Error()+";", exp) {
+			t.Errorf("error %q does not contain %q", err, exp)
+		}
+	}
+}
+
+func TestSliceStrategyOrder(t *testing.T) {
+	type sliceType struct{ S []int }
+	for _, test := range []struct {
+		strategy Strategy
+		l, r     []int
+		exp      []int
+	}{
+		{StrategyKeep, []int{1}, []int{5, 6}, []int{1, 6}},
+		{StrategyKeep, []int{1, 2}, []int{5}, []int{1, 2}},
+		{StrategyOverwrite, []int{1, 2}, []int{5, 6, 7}, []int{5, 6, 7}},
+		{StrategyOverwrite, []int{1, 2, 3}, []int{5}, []int{5, 2, 3}},
+		{StrategyMin, []int{1}, []int{5, 0}, []int{1, 0}},
+	} {
+		f := MustGen(new(sliceType), WithStrategy("S", test.strategy))
+		l, r := sliceType{test.l}, sliceType{test.r}
+		f(&l, &r)
+		if !reflect.DeepEqual(l.S, test.exp) {
+			t.Errorf("%v %v %v: got %v != exp %v", test.strategy, test.l, test.r, l.S, test.exp)
+		}
+	}
+}
@@ -0,0 +1,312 @@
+package mergetyp
+
+import (
+	"bufio"
+	"fmt"
+	"io"
+	"reflect"
+	"strconv"
+	"strings"
+)
+
+// Action is what a merge function does with a value.
+type Action uint8
+
+const (
+	// ActionMerge merges a number or bool with a strategy.
+	ActionMerge Action = iota
+	// ActionStruct merges every field of a struct.
+	ActionStruct
+	// ActionPointer adopts the right pointer if the left is nil, and
+	// otherwise merges what the pointers point to.
+	ActionPointer
+	// ActionArray merges every element of an array.
+	ActionArray
+	// ActionSlice keeps the longer slice on the left and merges the
+	// elements that both slices have.
+	ActionSlice
+	// ActionMap adopts the values of keys only the right map has, and
+	// merges the values of keys both maps have.
+	ActionMap
+	// ActionRecursive merges a struct with the function of the same
+	// struct that it is within.
+	ActionRecursive
+	// ActionSkip leaves the left value as it is.
+	ActionSkip
+	// ActionCustom merges with a function registered for the type.
+	ActionCustom
+	// ActionDynamic merges interfaces by their dynamic types.
+	ActionDynamic
+)
+
+func (a Action) String() string {
+	switch a {
+	case ActionMerge:
+		return "merge"
+	case ActionStruct:
+		return "struct"
+	case ActionPointer:
+		return "pointer"
+	case ActionArray:
+		return "array"
+	case ActionSlice:
+		return "slice"
+	case ActionMap:
+		return "map"
+	case ActionRecursive:
+		return "recursive"
+	case ActionSkip:
+		return "skip"
+	case ActionCustom:
+		return "custom"
+	case ActionDynamic:
+		return "dynamic"
+	default:
+		return "Action(" + strconv.Itoa(int(a)) + ")"
+	}
+}
+
+// PlanNode describes how a merge function merges one value, and contains the
+// nodes for the values within it.
+type PlanNode struct {
+	// Name is how this value is reached from its parent: a field name,
+	// [*] for all elements of an array or slice and [N] for a selected
+	// element, {*} for all values of a map and {key} for a selected
+	// key, and * for what a pointer points to. The root's name is empty.
+	Name string
+	// Type is the value's type.
+	Type string
+	// Action is what the merge function does with this value.
+	Action Action
+	// Strategy is the strategy used for ActionMerge.
+	Strategy Strategy
+	// Fast is whether an ActionMerge is done directly by offset in a
+	// struct or array, rather than through a closure.
+	Fast bool
+	// Reason is why the value is skipped, for ActionSkip.
+	Reason string
+	// Children are the nodes for the values within this value. Values
+	// that are skipped may still have children describing why nothing
+	// within them is merged.
+	Children []*PlanNode
+}
+
+// Plan returns a description of what the merge function that Gen returns for
+// the same input and options does with every value it can reach. Plan uses
+// the same code as Gen, and returns the same errors Gen would.
+//
+// Types are planned in full at every path they are reached at. A struct that
+// contains itself (through a pointer, slice, or map) is described as
+// ActionRecursive where it repeats.
+func Plan(i interface{}, options ...func(*Config) error) (*PlanNode, error) {
+	g, v, err := newGenerator(i, options)
+	if err != nil {
+		return nil, err
+	}
+	g.plan = new(PlanNode)
+	if _, err := g.gen(v); err != nil {
+		return nil, err
+	}
+	return g.plan, nil
+}
+
+// String returns the plan as indented text; see WriteText.
+func (n *PlanNode) String() string {
+	var sb strings.Builder
+	n.WriteText(&sb)
+	return sb.String()
+}
+
+// describe returns the node's action, with its strategy or skip reason.
+func (n *PlanNode) describe() string {
+	switch n.Action {
+	case ActionMerge:
+		s := n.Strategy.String()
+		if n.Type == timeType.String() {
+			s = timeStrategy(n.Strategy)
+		}
+		if n.Fast {
+			return "merge " + s + " (fast)"
+		}
+		return "merge " + s
+	case ActionSkip:
+		return "skip (" + n.Reason + ")"
+	default:
+		return n.Action.String()
+	}
+}
+
+// label returns the node's name and type.
+func (n *PlanNode) label() string {
+	if n.Name == "" {
+		return n.Type
+	}
+	return n.Name + " " + n.Type
+}
+
+// WriteText writes the plan as text, one value per line, with values within
+// other values indented by two spaces. Each line is the value's name, type,
+// and what is done with it:
+//
+//     mergetyp.MyType: struct
+//       Count int64: merge sum (fast)
+//       Next *mergetyp.MyType: pointer
+//         * mergetyp.MyType: recursive
+//       Labels map[string]int: skip (skipped by path "Labels")
+//
+// The output is stable for a given type and options, and is suitable for
+// golden files.
+func (n *PlanNode) WriteText(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	var write func(*PlanNode, int)
+	write = func(n *PlanNode, depth int) {
+		fmt.Fprintf(bw, "%s%s: %s\n", strings.Repeat("  ", depth), n.label(), n.describe())
+		for _, c := range n.Children {
+			write(c, depth+1)
+		}
+	}
+	write(n, 0)
+	return bw.Flush()
+}
+
+// WriteDot writes the plan as a Graphviz DOT digraph. Every value is a node
+// with an edge from the value it is within. Skipped values are dashed and
+// gray, and recursive values have a dashed back edge to the struct they
+// repeat.
+func (n *PlanNode) WriteDot(w io.Writer) error {
+	bw := bufio.NewWriter(w)
+	quote := strings.NewReplacer(`\`, `\\`, `"`, `\"`).Replace
+
+	fmt.Fprintln(bw, "digraph plan {")
+	fmt.Fprintln(bw, "\tnode [shape=box];")
+
+	id := 0
+	var stack []*PlanNode
+	var ids []int
+	var write func(*PlanNode, bool) int
+	write = func(n *PlanNode, skipped bool) int {
+		me := id
+		id++
+		skipped = skipped || n.Action == ActionSkip
+		attrs := ""
+		if skipped {
+			attrs = ", style=dashed, color=gray"
+		}
+		fmt.Fprintf(bw, "\tn%d [label=\"%s\\n%s\"%s];\n", me, quote(n.label()), quote(n.describe()), attrs)
+
+		if n.Action == ActionRecursive {
+			for i := len(stack) - 1; i >= 0; i-- {
+				if stack[i].Type == n.Type && stack[i].Action == ActionStruct {
+					fmt.Fprintf(bw, "\tn%d -> n%d [style=dashed, constraint=false, label=\"recursive\"];\n", me, ids[i])
+					break
+				}
+			}
+		}
+
+		stack = append(stack, n)
+		ids = append(ids, me)
+		for _, c := range n.Children {
+			cid := write(c, skipped)
+			attrs := ""
+			if skipped || c.Action == ActionSkip {
+				attrs = " [style=dashed, color=gray]"
+			}
+			fmt.Fprintf(bw, "\tn%d -> n%d%s;\n", me, cid, attrs)
+		}
+		stack = stack[:len(stack)-1]
+		ids = ids[:len(ids)-1]
+		return me
+	}
+	write(n, false)
+
+	fmt.Fprintln(bw, "}")
+	return bw.Flush()
+}
+
+// The functions below are used while generating and are safe to call on a
+// nil node, which is what we have if we are not planning.
+
+func (n *PlanNode) child(name string) *PlanNode {
+	if n == nil {
+		return nil
+	}
+	c := &PlanNode{Name: name}
+	n.Children = append(n.Children, c)
+	return c
+}
+
+func (n *PlanNode) set(k reflect.Kind, s Strategy) {
+	if n == nil {
+		return
+	}
+	switch k {
+	case reflect.Ptr:
+		n.Action = ActionPointer
+	case reflect.Array:
+		n.Action = ActionArray
+	case reflect.Slice:
+		n.Action = ActionSlice
+	case reflect.Map:
+		n.Action = ActionMap
+	case reflect.Struct:
+		n.Action = ActionStruct
+	default:
+		n.merged(s)
+	}
+}
+
+func (n *PlanNode) merged(s Strategy) {
+	if n != nil {
+		n.Action = ActionMerge
+		n.Strategy = s
+	}
+}
+
+func (n *PlanNode) custom() {
+	if n != nil {
+		n.Action = ActionCustom
+	}
+}
+
+func (n *PlanNode) dynamic() {
+	if n != nil {
+		n.Action = ActionDynamic
+	}
+}
+
+func (n *PlanNode) fast() {
+	if n == nil {
+		return
+	}
+	n.Action = ActionMerge
+	n.Strategy = StrategySum
+	n.Fast = true
+}
+
+func (n *PlanNode) recursive() {
+	if n != nil {
+		n.Action = ActionRecursive
+	}
+}
+
+func (n *PlanNode) skipped(reason string) {
+	if n != nil {
+		n.Action = ActionSkip
+		n.Strategy = StrategySum
+		n.Reason = reason
+	}
+}
+
+// fastKind returns whether a kind is merged directly by offset when summed
+// within a struct, array, or slice.
+func fastKind(k reflect.Kind) bool {
+	switch k {
+	case reflect.Bool,
+		reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64,
+		reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64,
+		reflect.Float32, reflect.Float64,
+		reflect.Complex64, reflect.Complex128:
+		return true
+	}
+	return false
+}
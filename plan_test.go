@@ -0,0 +1,151 @@
+package mergetyp
+
+import (
+	"reflect"
+	"strings"
+	"testing"
+)
+
+type planInner struct {
+	A int
+	B uint8
+}
+
+type planType struct {
+	Count  int64
+	Max    int
+	Inner  planInner
+	Ptr    *planInner
+	Arr    [2]planInner
+	Shards []int
+	Skip   chan int
+	Empty  struct{}
+}
+
+func TestPlan(t *testing.T) {
+	plan, err := Plan(new(planType),
+		SkipFields("Skip", "Arr[1]"),
+		WithStrategy("Max", StrategyMax),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	fast := func(name, typ string) *PlanNode {
+		return &PlanNode{Name: name, Type: typ, Action: ActionMerge, Fast: true}
+	}
+	inner := func(name string) *PlanNode {
+		return &PlanNode{Name: name, Type: "mergetyp.planInner", Action: ActionStruct, Children: []*PlanNode{
+			fast("A", "int"),
+			fast("B", "uint8"),
+		}}
+	}
+
+	exp := &PlanNode{Type: "mergetyp.planType", Action: ActionStruct, Children: []*PlanNode{
+		fast("Count", "int64"),
+		{Name: "Max", Type: "int", Action: ActionMerge, Strategy: StrategyMax},
+		inner("Inner"),
+		{Name: "Ptr", Type: "*mergetyp.planInner", Action: ActionPointer, Children: []*PlanNode{
+			inner("*"),
+		}},
+		{Name: "Arr", Type: "[2]mergetyp.planInner", Action: ActionArray, Children: []*PlanNode{
+			inner("[*]"),
+			{Name: "[1]", Type: "mergetyp.planInner", Action: ActionSkip, Reason: `skipped by path "Arr[1]"`},
+		}},
+		{Name: "Shards", Type: "[]int", Action: ActionSlice, Children: []*PlanNode{
+			fast("[*]", "int"),
+		}},
+		{Name: "Skip", Type: "chan int", Action: ActionSkip, Reason: `skipped by path "Skip"`},
+		{Name: "Empty", Type: "struct {}", Action: ActionSkip, Reason: "nothing to merge"},
+	}}
+
+	if !reflect.DeepEqual(plan, exp) {
+		t.Errorf("plan mismatch:\ngot  %s\nexp  %s", dump(plan), dump(exp))
+	}
+
+	if _, err := Plan(new(planType)); err == nil {
+		t.Error("expected Plan to fail like Gen on the unmergeable channel")
+	}
+
+	plan, err = Plan(new(recursive))
+	if err != nil {
+		t.Fatal(err)
+	}
+	exp = &PlanNode{Type: "mergetyp.recursive", Action: ActionStruct, Children: []*PlanNode{
+		fast("i", "int"),
+		{Name: "next", Type: "*mergetyp.recursive", Action: ActionPointer, Children: []*PlanNode{
+			{Name: "*", Type: "mergetyp.recursive", Action: ActionRecursive},
+		}},
+	}}
+	if !reflect.DeepEqual(plan, exp) {
+		t.Errorf("plan mismatch:\ngot  %s\nexp  %s", dump(plan), dump(exp))
+	}
+}
+
+func dump(n *PlanNode) string {
+	s := "{" + n.Name + " " + n.Type + " " + n.Action.String() + " " + n.Strategy.String() + " " + n.Reason
+	if n.Fast {
+		s += " fast"
+	}
+	for _, c := range n.Children {
+		s += " " + dump(c)
+	}
+	return s + "}"
+}
+
+type renderType struct {
+	Count int64
+	Hits  int64
+	Next  *renderType
+}
+
+func TestPlanRender(t *testing.T) {
+	plan, err := Plan(new(renderType), SkipField("Hits"), WithStrategy("Next", StrategyMax))
+	if err != nil {
+		t.Fatal(err)
+	}
+
+	expText := `mergetyp.renderType: struct
+  Count int64: merge sum (fast)
+  Hits int64: skip (skipped by path "Hits")
+  Next *mergetyp.renderType: pointer
+    * mergetyp.renderType: struct
+      Count int64: merge max
+      Hits int64: merge max
+      Next *mergetyp.renderType: pointer
+        * mergetyp.renderType: recursive
+`
+	if got := plan.String(); got != expText {
+		t.Errorf("text mismatch:\ngot:\n%s\nexp:\n%s", got, expText)
+	}
+
+	expDot := `digraph plan {
+	node [shape=box];
+	n0 [label="mergetyp.renderType\nstruct"];
+	n1 [label="Count int64\nmerge sum (fast)"];
+	n0 -> n1;
+	n2 [label="Hits int64\nskip (skipped by path \"Hits\")", style=dashed, color=gray];
+	n0 -> n2 [style=dashed, color=gray];
+	n3 [label="Next *mergetyp.renderType\npointer"];
+	n4 [label="* mergetyp.renderType\nstruct"];
+	n5 [label="Count int64\nmerge max"];
+	n4 -> n5;
+	n6 [label="Hits int64\nmerge max"];
+	n4 -> n6;
+	n7 [label="Next *mergetyp.renderType\npointer"];
+	n8 [label="* mergetyp.renderType\nrecursive"];
+	n8 -> n4 [style=dashed, constraint=false, label="recursive"];
+	n7 -> n8;
+	n4 -> n7;
+	n3 -> n4;
+	n0 -> n3;
+}
+`
+	var sb strings.Builder
+	if err := plan.WriteDot(&sb); err != nil {
+		t.Fatal(err)
+	}
+	if got := sb.String(); got != expDot {
+		t.Errorf("dot mismatch:\ngot:\n%s\nexp:\n%s", got, expDot)
+	}
+}
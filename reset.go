@@ -0,0 +1,422 @@
+package mergetyp
+
+import (
+	"errors"
+	"fmt"
+	"math"
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+// This file contains the logic to recursively generate reset functions, which
+// reset exactly what a merge function merges.
+
+type resetF = func(unsafe.Pointer)
+
+// GenReset returns a function that resets everything in a value that a merge
+// function generated with the same options would merge, leaving everything
+// else intact. This is useful to start aggregating anew after shipping an
+// aggregated value, while keeping fields that are not merged, such as
+// configuration, labels, or locks.
+//
+// Numbers and bools are reset to what merging with their strategy starts
+// from: summed numbers are zeroed, numbers merged with StrategyMin are set to
+// the largest value of their type (infinity for floats), and numbers merged
+// with StrategyMax are set to the smallest. Bools merged with StrategyMin are
+// set to true, and all other bools are set to false. Times are set to the
+// zero time. Big numbers are set to zero, or to infinity for big floats
+// merged with StrategyMin or StrategyMax; big integers and rationals merged
+// with these strategies cannot be reset. Merging into a reset value
+// therefore results in the merged value.
+//
+// Slices whose elements are merged entirely are truncated, keeping their
+// capacity; otherwise, the merged parts of every element are reset in place.
+// Similarly, map keys whose values are merged entirely are deleted, and
+// values that are merged in part are reset in place; keys that are skipped
+// are left alone. Pointers are kept, and what they point to is reset in
+// place.
+//
+// Options that cause Gen to fail cause GenReset to fail. The input type must
+// be a singly-indirected value, and the returned function panics if used on
+// other types, the same as with Gen.
+func GenReset(i interface{}, options ...func(*Config) error) (func(v interface{}), error) {
+	g, v, err := newGenerator(i, options)
+	if err != nil {
+		return nil, err
+	}
+	g.resetFs = make(map[structKey]*resetF)
+	f, _, err := g.reset(v)
+	if err != nil {
+		return nil, err
+	}
+
+	check := typeChecker(i, "reset")
+	if f == nil { // nothing inside to reset
+		return func(v interface{}) { check(v) }, nil
+	}
+	return func(v interface{}) { f(check(v)) }, nil
+}
+
+// MustGenReset is like GenReset but panics if the reset function cannot be
+// generated.
+func MustGenReset(i interface{}, options ...func(*Config) error) func(v interface{}) {
+	f, err := GenReset(i, options...)
+	if err != nil {
+		panic(err)
+	}
+	return f
+}
+
+// identity returns the value that merging a primitive of type t with strategy
+// s starts from. This returns false if t is not a primitive.
+func identity(t reflect.Type, s Strategy) (reflect.Value, bool) {
+	v := reflect.New(t).Elem()
+	switch t.Kind() {
+	case reflect.Bool:
+		v.SetBool(s == StrategyMin)
+	case reflect.Int, reflect.Int8, reflect.Int16, reflect.Int32, reflect.Int64:
+		switch s {
+		case StrategyMin:
+			v.SetInt(math.MaxInt64 >> (64 - t.Bits()))
+		case StrategyMax:
+			v.SetInt(math.MinInt64 >> (64 - t.Bits()))
+		}
+	case reflect.Uint, reflect.Uint8, reflect.Uint16, reflect.Uint32, reflect.Uint64, reflect.Uintptr:
+		if s == StrategyMin {
+			v.SetUint(math.MaxUint64 >> (64 - t.Bits()))
+		}
+	case reflect.Float32, reflect.Float64:
+		switch s {
+		case StrategyMin:
+			v.SetFloat(math.Inf(1))
+		case StrategyMax:
+			v.SetFloat(math.Inf(-1))
+		}
+	case reflect.Complex64, reflect.Complex128:
+	default:
+		return v, false
+	}
+	return v, true
+}
+
+// reset generates a closure to reset an arbitrary value. This returns nil if
+// there is nothing to reset, and whether the closure resets the entire value,
+// that is, whether nothing within the value is skipped.
+func (g *generator) reset(v reflect.Value) (resetF, bool, error) {
+	if g.skip != "" {
+		return nil, false, nil
+	}
+	t := v.Type()
+
+	// Registered resetters take precedence over everything, and we must
+	// not reach into types with registered mergers.
+	if f, ok := g.resetters[t]; ok {
+		if len(g.rules) > 0 {
+			return nil, false, fmt.Errorf("unable to skip or select fields in %v, which has a registered resetter", t)
+		}
+		return f, true, nil
+	}
+	if _, ok := g.mergers[t]; ok {
+		return nil, false, fmt.Errorf("unable to reset %v, which has a registered merger (register a resetter with WithTypeResetter)", t)
+	}
+	if f, whole, ok, err := g.resetSyncAtomic(t); ok {
+		return f, whole, err
+	}
+	if f, ok, err := g.resetTree(t); ok {
+		return f, true, err
+	}
+
+	if len(g.rules) > 0 {
+		switch t.Kind() {
+		case reflect.Slice, reflect.Struct, reflect.Array, reflect.Ptr, reflect.Map:
+		default:
+			return nil, false, fmt.Errorf("unable to skip or select fields on kind %v", t.Kind())
+		}
+	}
+
+	// Primitives are reset by copying their identity over them. If our
+	// entire value is summed primitives, we can clear it all at once.
+	if g.strategy == StrategySum && g.bytewise(t) && hasNumbers(t) {
+		size := t.Size()
+		return func(p unsafe.Pointer) { clear(unsafe.Slice((*byte)(p), size)) }, true, nil
+	}
+	if g.strategy != StrategySum {
+		if _, ok, err := genStrategy(t.Kind(), g.strategy); ok && err != nil {
+			return nil, false, err
+		}
+	}
+	if isBig(t) {
+		f, err := g.resetBig(t)
+		return f, f != nil, err
+	}
+	if t == timeType && len(g.rules) == 0 {
+		return func(p unsafe.Pointer) { *(*time.Time)(p) = time.Time{} }, true, nil
+	}
+	if id, ok := identity(t, g.strategy); ok {
+		size := t.Size()
+		idp := id.Addr().UnsafePointer()
+		return func(p unsafe.Pointer) { memmove(p, idp, size) }, true, nil
+	}
+
+	switch t.Kind() {
+	case reflect.Interface:
+		if g.interfaces {
+			f, err := g.resetInterface(t)
+			return f, false, err
+		}
+		return nil, false, errors.New("it is impossible to reset types that are interfaces (unable to determine concrete type)")
+	case reflect.Chan:
+		return nil, false, errors.New("unable to reset channels")
+	case reflect.Func:
+		return nil, false, errors.New("unable to reset functions")
+	case reflect.String:
+		return nil, false, errors.New("unable to reset strings")
+	case reflect.UnsafePointer:
+		return nil, false, errors.New("unable to reset unsafe pointers (unable to determine the type)")
+	case reflect.Invalid:
+		return nil, false, errors.New("unable to reset an invalid type")
+
+	case reflect.Ptr:
+		f, whole, err := g.reset(reflect.Zero(t.Elem()))
+		if err != nil || f == nil {
+			return nil, false, err
+		}
+		return func(p unsafe.Pointer) {
+			if pp := *(*unsafe.Pointer)(p); pp != nil {
+				f(pp)
+			}
+		}, whole, nil
+
+	case reflect.Array:
+		n := v.Len()
+		def, specials, err := g.splitIndices(n)
+		if err != nil {
+			return nil, false, err
+		}
+		f, whole, err := resetElems(t.Elem(), def, specials)
+		if err != nil || f == nil {
+			return nil, false, err
+		}
+		return func(p unsafe.Pointer) { f(p, n) }, whole, nil
+
+	case reflect.Slice:
+		def, specials, err := g.splitIndices(-1)
+		if err != nil {
+			return nil, false, err
+		}
+		f, whole, err := resetElems(t.Elem(), def, specials)
+		if err != nil || f == nil {
+			return nil, false, err
+		}
+		if whole {
+			return func(p unsafe.Pointer) { (*sliceHeader)(p).len = 0 }, true, nil
+		}
+		return func(p unsafe.Pointer) {
+			h := (*sliceHeader)(p)
+			f(h.data, h.len)
+		}, false, nil
+
+	case reflect.Struct:
+		if len(g.rules) > 0 {
+			return g.resetStruct(v)
+		}
+
+		// Recursive structs are handled the same as when merging. We
+		// do not know if a struct we are within is reset entirely, so
+		// we conservatively say it is not.
+		key := structKey{t, g.strategy}
+		pf, exists := g.resetFs[key]
+		if exists {
+			return func(p unsafe.Pointer) { (*pf)(p) }, false, nil
+		}
+		pf = new(resetF)
+		g.resetFs[key] = pf
+		if len(g.filters) > 0 {
+			defer delete(g.resetFs, key)
+		}
+		f, whole, err := g.resetStruct(v)
+		if err != nil {
+			return nil, false, err
+		}
+		if f == nil {
+			delete(g.resetFs, key)
+		}
+		*pf = f
+		return f, whole, nil
+
+	case reflect.Map:
+		if !g.useMap {
+			return nil, false, errors.New("unable to reset maps: use WithSlowerMapsUnsafely if it is absolutely necessary to reset maps")
+		}
+		return g.resetMap(v)
+
+	default:
+		panic("this switch statement should be comprehensive?")
+	}
+}
+
+// hasNumbers returns whether a value of type t contains any numbers or bools,
+// that is, whether there is anything to reset in a pointer free type.
+func hasNumbers(t reflect.Type) bool {
+	switch t.Kind() {
+	case reflect.Array:
+		return t.Len() > 0 && hasNumbers(t.Elem())
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			if hasNumbers(t.Field(i).Type) {
+				return true
+			}
+		}
+		return false
+	default:
+		return true
+	}
+}
+
+// resetElems generates the closure to reset n elements of type et, starting
+// at a pointer. This returns nil if there is nothing to reset, and whether
+// every element is reset entirely.
+func resetElems(et reflect.Type, def *generator, specials map[int]*generator) (func(p unsafe.Pointer, n int), bool, error) {
+	z := reflect.Zero(et)
+	f, whole, err := def.reset(z)
+	if err != nil {
+		return nil, false, err
+	}
+	sfs := make(map[int]resetF, len(specials))
+	for index, c := range specials {
+		sf, swhole, err := c.reset(z)
+		if err != nil {
+			return nil, false, err
+		}
+		sfs[index] = sf
+		whole = whole && swhole
+	}
+	if f == nil && len(sfs) == 0 {
+		return nil, false, nil
+	}
+
+	size := et.Size()
+	return func(p unsafe.Pointer, n int) {
+		for i := 0; i < n; i++ {
+			ef := f
+			if sf, exists := sfs[i]; exists {
+				ef = sf
+			}
+			if ef != nil {
+				ef(fieldByOffset(p, uintptr(i)*size))
+			}
+		}
+	}, whole, nil
+}
+
+// resetMap generates the closure to reset a map.
+func (g *generator) resetMap(v reflect.Value) (resetF, bool, error) {
+	t := v.Type()
+	et := t.Elem()
+
+	def, specials, err := g.splitKeys(t.Key())
+	if err != nil {
+		return nil, false, err
+	}
+	ez := reflect.Zero(et)
+	f, whole, err := def.reset(ez)
+	if err != nil {
+		return nil, false, err
+	}
+	// Keys whose values are reset entirely are deleted; we track this
+	// per key alongside the functions to reset values in place.
+	type keyF struct {
+		f     resetF
+		whole bool
+	}
+	var kfs map[interface{}]keyF
+	if specials != nil {
+		kfs = make(map[interface{}]keyF, len(specials))
+		for k, c := range specials {
+			kf, kwhole, err := c.reset(ez)
+			if err != nil {
+				return nil, false, err
+			}
+			kfs[k] = keyF{kf, kwhole}
+		}
+	}
+	if f == nil && kfs == nil {
+		return nil, false, nil
+	}
+
+	if whole && kfs == nil {
+		return func(p unsafe.Pointer) {
+			m := reflect.NewAt(t, p).Elem()
+			if !m.IsNil() {
+				m.Clear()
+			}
+		}, true, nil
+	}
+
+	var zv reflect.Value
+	return func(p unsafe.Pointer) {
+		m := reflect.NewAt(t, p).Elem()
+		iter := m.MapRange()
+		for iter.Next() {
+			k := iter.Key()
+			f, whole := f, whole
+			if kfs != nil {
+				if kf, exists := kfs[k.Interface()]; exists {
+					f, whole = kf.f, kf.whole
+				}
+			}
+			if f == nil {
+				continue
+			}
+			if whole {
+				m.SetMapIndex(k, zv)
+				continue
+			}
+			ev := reflect.New(et)
+			ev.Elem().Set(iter.Value())
+			f(ev.UnsafePointer())
+			m.SetMapIndex(k, ev.Elem())
+		}
+	}, false, nil
+}
+
+// resetStruct generates a closure to reset a struct.
+func (g *generator) resetStruct(v reflect.Value) (resetF, bool, error) {
+	t := v.Type()
+	field, check, err := g.fields(t)
+	if err != nil {
+		return nil, false, err
+	}
+
+	type offsetF struct {
+		offset uintptr
+		f      resetF
+	}
+	var offsetFs []offsetF
+	whole := true
+	for i := 0; i < t.NumField(); i++ {
+		c := field(i)
+		f, fwhole, err := c.reset(v.Field(i))
+		if err != nil {
+			return nil, false, err
+		}
+		whole = whole && fwhole
+		if f != nil {
+			offsetFs = append(offsetFs, offsetF{t.Field(i).Offset, f})
+		}
+	}
+
+	if err := check(); err != nil {
+		return nil, false, err
+	}
+
+	if len(offsetFs) == 0 {
+		return nil, whole, nil
+	}
+	return func(p unsafe.Pointer) {
+		for _, of := range offsetFs {
+			of.f(fieldByOffset(p, of.offset))
+		}
+	}, whole, nil
+}
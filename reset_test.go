@@ -0,0 +1,97 @@
+package mergetyp
+
+import (
+	"math"
+	"reflect"
+	"sync"
+	"testing"
+)
+
+type resetInner struct {
+	N    int
+	Name string
+}
+
+type resetType struct {
+	mu      sync.Mutex
+	Name    string
+	Count   uint32
+	Low     int16
+	High    float64
+	All     bool
+	Buckets [2]int
+	Inner   resetInner
+	Ptr     *resetInner
+	Counts  []int64
+	Elems   []resetInner
+	Labels  map[string]int
+	Named   map[string][2]int
+}
+
+func TestGenReset(t *testing.T) {
+	reset := MustGenReset(new(resetType),
+		WithSlowerMapsUnsafely(),
+		SkipFields("mu", "Name", "Inner>Name", "Ptr>Name", "Elems>Name", `Named{"a"}[1]`, `Labels{"keep"}`),
+		WithStrategy("Low", StrategyMin),
+		WithStrategy("High", StrategyMax),
+		WithStrategy("All", StrategyMin),
+	)
+
+	counts := []int64{1, 2}
+	v := resetType{
+		Name:    "name",
+		Count:   1,
+		Low:     2,
+		High:    3,
+		Buckets: [2]int{4, 5},
+		Inner:   resetInner{6, "inner"},
+		Ptr:     &resetInner{7, "ptr"},
+		Counts:  counts,
+		Elems:   []resetInner{{8, "a"}, {9, "b"}},
+		Labels:  map[string]int{"a": 1, "keep": 2},
+		Named:   map[string][2]int{"a": {1, 2}, "b": {3, 4}},
+	}
+	reset(&v)
+
+	exp := resetType{
+		Name:   "name",
+		Low:    math.MaxInt16,
+		High:   math.Inf(-1),
+		All:    true,
+		Inner:  resetInner{0, "inner"},
+		Ptr:    &resetInner{0, "ptr"},
+		Counts: counts[:0],
+		Elems:  []resetInner{{0, "a"}, {0, "b"}},
+		Labels: map[string]int{"keep": 2},
+		Named:  map[string][2]int{"a": {0, 2}},
+	}
+	if !reflect.DeepEqual(&v, &exp) {
+		t.Fatalf("got %#v != exp %#v", &v, &exp)
+	}
+	if cap(v.Counts) != 2 {
+		t.Errorf("truncated slice lost its capacity")
+	}
+
+	for _, test := range []struct {
+		name string
+		opts []func(*Config) error
+	}{
+		{"strings", []func(*Config) error{WithSlowerMapsUnsafely(), SkipFields("mu", "Inner>Name", "Ptr>Name", "Elems>Name", `Named{"a"}[1]`)}},
+		{"unknown field", []func(*Config) error{SkipField("Missing")}},
+	} {
+		if _, err := GenReset(new(resetType), test.opts...); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
+
+func TestGenResetAnonymous(t *testing.T) {
+	var v anonymous
+	v.A.X, v.A.P = 1, []int64{2}
+	v.B.Y, v.B.Q, v.B.Z = 3, []int64{4}, 5
+
+	MustGenReset(new(anonymous))(&v)
+	if v.A.X != 0 || len(v.A.P) != 0 || v.B.Y != 0 || len(v.B.Q) != 0 || v.B.Z != 0 {
+		t.Errorf("got %+v, exp zeros", v)
+	}
+}
@@ -0,0 +1,132 @@
+package mergetyp
+
+import (
+	"errors"
+	"fmt"
+	"reflect"
+	"unsafe"
+
+	"github.com/twmb/go-mergetyp/internal/fieldpath"
+)
+
+// SkipOf is like SkipFields, but fields are selected with functions that
+// return a pointer to the field to skip rather than with string paths:
+//
+//     SkipOf(func(t *MyType) any { return &t.Foo.Bar })
+//
+// Because fields are referenced in code, renaming a field renames it here as
+// well, and a field that no longer exists fails to compile rather than
+// failing in Gen.
+//
+// Selector functions are called once with a pointer to a zero T, and must
+// return a pointer into that T. Selectors can go through struct fields and
+// array elements (&t.Buckets[63] selects index 63), but not through pointers,
+// slices, or maps, which are nil in the zero T. The resulting options can only
+// be used in Gen for a *T.
+func SkipOf[T any](sels ...func(t *T) any) func(*Config) error {
+	return selectorRules(actSkip, 0, sels)
+}
+
+// OnlyOf is like OnlyFields, but fields are selected with functions as
+// described in SkipOf.
+func OnlyOf[T any](sels ...func(t *T) any) func(*Config) error {
+	return selectorRules(actOnly, 0, sels)
+}
+
+// StrategyOf is like WithStrategy, but fields are selected with functions as
+// described in SkipOf.
+func StrategyOf[T any](strategy Strategy, sels ...func(t *T) any) func(*Config) error {
+	return func(c *Config) error {
+		if strategy > StrategyOverwrite {
+			return fmt.Errorf("unknown strategy %v", strategy)
+		}
+		return selectorRules(actStrategy, strategy, sels)(c)
+	}
+}
+
+func selectorRules[T any](act action, strategy Strategy, sels []func(*T) any) func(*Config) error {
+	return func(c *Config) error {
+		typ := reflect.TypeOf((*T)(nil)).Elem()
+		for _, sel := range sels {
+			steps, err := selectSteps(typ, sel)
+			if err != nil {
+				return err
+			}
+			parsed := make([]fieldpath.Step, 0, len(steps))
+			for _, s := range steps {
+				parsed = append(parsed, s.Step)
+			}
+			c.rules = append(c.rules, rule{
+				act:      act,
+				strategy: strategy,
+				steps:    steps,
+				path:     fieldpath.Format(parsed),
+				typ:      typ,
+			})
+		}
+		return nil
+	}
+}
+
+// selectSteps calls sel on a zero T and resolves the returned pointer into
+// the steps to reach it.
+func selectSteps[T any](typ reflect.Type, sel func(*T) any) (steps []step, err error) {
+	defer func() {
+		if recover() != nil {
+			err = fmt.Errorf("selector for %v panicked: selectors cannot go through pointers, slices, or maps", typ)
+		}
+	}()
+
+	t := new(T)
+	p := reflect.ValueOf(sel(t))
+	if p.Kind() != reflect.Ptr || p.IsNil() {
+		return nil, fmt.Errorf("selector for %v did not return a non-nil pointer", typ)
+	}
+
+	base := uintptr(unsafe.Pointer(t))
+	addr := p.Pointer()
+	if addr < base || addr >= base+typ.Size() {
+		return nil, fmt.Errorf("selector for %v returned a pointer outside of the value", typ)
+	}
+
+	steps, ok := findSteps(typ, addr-base, p.Type().Elem())
+	if !ok {
+		return nil, fmt.Errorf("unable to find the field selected in %v", typ)
+	}
+	if len(steps) == 0 {
+		return nil, errors.New("selectors must select a field, not the entire value")
+	}
+	return steps, nil
+}
+
+// findSteps returns the steps through struct fields and array elements in t
+// to reach a value of type target at offset off.
+func findSteps(t reflect.Type, off uintptr, target reflect.Type) ([]step, bool) {
+	if off == 0 && t == target {
+		return []step{}, true
+	}
+	switch t.Kind() {
+	case reflect.Struct:
+		for i := 0; i < t.NumField(); i++ {
+			sf := t.Field(i)
+			if off < sf.Offset || off > sf.Offset+sf.Type.Size() {
+				continue
+			}
+			if rest, ok := findSteps(sf.Type, off-sf.Offset, target); ok {
+				s := step{Step: fieldpath.Step{Kind: fieldpath.Field, Name: sf.Name, Index: i}, exact: true}
+				return append([]step{s}, rest...), true
+			}
+		}
+	case reflect.Array:
+		size := t.Elem().Size()
+		if size == 0 || off >= t.Size() {
+			return nil, false
+		}
+		index := off / size
+		if rest, ok := findSteps(t.Elem(), off-index*size, target); ok {
+			s := step{Step: fieldpath.Step{Kind: fieldpath.Index, Index: int(index)}}
+			return append([]step{s}, rest...), true
+		}
+	}
+	return nil, false
+}
@@ -0,0 +1,54 @@
+package mergetyp
+
+import (
+	"reflect"
+	"testing"
+)
+
+type selectorInner struct {
+	A int `json:"a"`
+	B int `json:"b"`
+}
+
+type selectorType struct {
+	Inner   selectorInner `json:"inner"`
+	Buckets [3]int
+	Ptr     *selectorInner
+	Other   int
+}
+
+func TestSelectors(t *testing.T) {
+	l := selectorType{selectorInner{1, 1}, [3]int{1, 1, 5}, &selectorInner{1, 1}, 1}
+	r := selectorType{selectorInner{2, 2}, [3]int{2, 2, 2}, &selectorInner{2, 2}, 2}
+
+	f, err := Gen(&l,
+		WithTagNames("json"),
+		SkipOf(func(t *selectorType) any { return &t.Inner.B }),
+		StrategyOf(StrategyMax, func(t *selectorType) any { return &t.Buckets[2] }),
+		OnlyOf(
+			func(t *selectorType) any { return &t.Inner },
+			func(t *selectorType) any { return &t.Buckets },
+		),
+	)
+	if err != nil {
+		t.Fatal(err)
+	}
+	f(&l, &r)
+
+	exp := selectorType{selectorInner{3, 1}, [3]int{3, 3, 5}, &selectorInner{1, 1}, 1}
+	if !reflect.DeepEqual(l, exp) {
+		t.Errorf("got %+v != exp %+v", l, exp)
+	}
+
+	for _, opt := range []func(*Config) error{
+		SkipOf(func(t *selectorType) any { return &t.Ptr.A }),
+		SkipOf(func(t *selectorType) any { return t }),
+		SkipOf(func(t *selectorType) any { return new(int) }),
+		SkipOf(func(t *selectorType) any { return 3 }),
+		SkipOf(func(t *selectorInner) any { return &t.A }),
+	} {
+		if _, err := Gen(&l, opt); err == nil {
+			t.Error("expected error")
+		}
+	}
+}
@@ -0,0 +1,17 @@
+// Command skipcheck checks the field paths passed to go-mergetyp options.
+//
+// It can be run directly or through go vet:
+//
+//     go vet -vettool=$(which skipcheck) ./...
+//
+// It depends on golang.org/x/tools; see the repository's README for how to
+// build it.
+package main
+
+import (
+	"golang.org/x/tools/go/analysis/singlechecker"
+
+	"github.com/twmb/go-mergetyp/skipcheck"
+)
+
+func main() { singlechecker.Main(skipcheck.Analyzer) }
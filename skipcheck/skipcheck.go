@@ -0,0 +1,406 @@
+// Package skipcheck defines an analyzer that checks the field paths passed to
+// go-mergetyp options against the type a merge function is generated for.
+//
+// The analyzer finds calls to functions that take options for a type: Gen,
+// GenClone, GenDiff, GenReset, and their Must forms, Plan, the accumulator
+// constructors, and mergetyptest.AssertPlan. It resolves the type from the
+// value or type argument of the call, and checks every constant string passed
+// to SkipField, SkipFields, OnlyFields, WithStrategy, and WithLocking in the
+// same call. A path that names a field that does not exist is otherwise only
+// discovered when Gen runs.
+//
+// Paths are checked with the same rules that Gen uses: struct fields are
+// separated by >, pointers are followed and arrays and slices are passed
+// through implicitly, fields promoted from embedded structs can be named
+// directly, and [N], [*], and {key} select into arrays, slices, and maps. Keys
+// are parsed for the map's key type as Gen parses them, and paths cannot
+// select fields within times, math/big numbers, or sync/atomic types. If the
+// call also passes WithTagNames with a constant tag key, fields are named by
+// that tag.
+//
+// Options that are not passed directly in the Gen call, such as options
+// built into a slice elsewhere, are not checked. Options that change which
+// types can be selected into are not considered either: paths into types with
+// a merger registered with WithTypeMerger, or into trees with WithTrees, pass
+// the analyzer but fail Gen.
+package skipcheck
+
+import (
+	"fmt"
+	"go/ast"
+	"go/constant"
+	"go/types"
+	"reflect"
+	"strings"
+
+	"golang.org/x/tools/go/analysis"
+	"golang.org/x/tools/go/analysis/passes/inspect"
+	"golang.org/x/tools/go/ast/inspector"
+	"golang.org/x/tools/go/types/typeutil"
+
+	"github.com/twmb/go-mergetyp/internal/fieldpath"
+)
+
+const (
+	mergetypPath     = "github.com/twmb/go-mergetyp"
+	mergetyptestPath = mergetypPath + "/mergetyptest"
+)
+
+// Analyzer checks the field paths in go-mergetyp options.
+var Analyzer = &analysis.Analyzer{
+	Name:     "skipcheck",
+	Doc:      "check that paths passed to go-mergetyp options name fields that exist",
+	Requires: []*analysis.Analyzer{inspect.Analyzer},
+	Run:      run,
+}
+
+func run(pass *analysis.Pass) (interface{}, error) {
+	insp := pass.ResultOf[inspect.Analyzer].(*inspector.Inspector)
+	insp.Preorder([]ast.Node{(*ast.CallExpr)(nil)}, func(n ast.Node) {
+		call := n.(*ast.CallExpr)
+		typ, options, ok := target(pass, call)
+		if !ok || len(options) == 0 || call.Ellipsis.IsValid() {
+			return
+		}
+
+		// We need the tag naming before checking any path.
+		var tagKey string
+		for _, arg := range options {
+			opt, ok := arg.(*ast.CallExpr)
+			if !ok || mergetypFunc(pass, opt) != "WithTagNames" || len(opt.Args) != 1 {
+				continue
+			}
+			key, ok := constString(pass, opt.Args[0])
+			if !ok {
+				return // we cannot know how fields are named
+			}
+			tagKey = key
+		}
+
+		c := &checker{tagKey: tagKey, sizes: pass.TypesSizes}
+		for _, arg := range options {
+			opt, ok := arg.(*ast.CallExpr)
+			if !ok {
+				continue
+			}
+			name := mergetypFunc(pass, opt)
+			var paths []ast.Expr
+			switch name {
+			case "SkipField", "SkipFields", "OnlyFields", "WithLocking":
+				paths = opt.Args
+			case "WithStrategy":
+				if len(opt.Args) > 0 {
+					paths = opt.Args[:1]
+				}
+			}
+			for _, p := range paths {
+				path, ok := constString(pass, p)
+				if !ok || name == "WithStrategy" && path == "" {
+					continue
+				}
+				if err := c.check(typ, path); err != nil {
+					pass.Reportf(p.Pos(), "invalid %s path %q for %v: %v", name, path, typ, err)
+				}
+			}
+		}
+	})
+	return nil, nil
+}
+
+// target returns the type that a called function generates for and the
+// options passed to it. This returns false if the function does not take
+// options for a type.
+func target(pass *analysis.Pass, call *ast.CallExpr) (types.Type, []ast.Expr, bool) {
+	var value, options int // the argument of the type and the first option
+	switch name := mergetypFunc(pass, call); name {
+	case "Gen", "MustGen",
+		"GenClone", "MustGenClone",
+		"GenDiff", "MustGenDiff",
+		"GenReset", "MustGenReset",
+		"Plan":
+		value, options = 0, 1
+	case "NewAccumulator", "MustNewAccumulator",
+		"NewShardedAccumulator", "MustNewShardedAccumulator":
+		// Accumulators generate for their type argument, and sharded
+		// accumulators take the number of shards first.
+		if strings.Contains(name, "Sharded") {
+			options = 1
+		}
+		typ := typeArg(pass, call)
+		if typ == nil || len(call.Args) < options {
+			return nil, nil, false
+		}
+		return typ, call.Args[options:], true
+	default:
+		fn, ok := typeutil.Callee(pass.TypesInfo, call).(*types.Func)
+		if !ok || fn.Pkg() == nil || fn.Pkg().Path() != mergetyptestPath || fn.Name() != "AssertPlan" {
+			return nil, nil, false
+		}
+		value, options = 1, 3 // AssertPlan(t, i, golden, options...)
+	}
+	if len(call.Args) < options {
+		return nil, nil, false
+	}
+	ptr, ok := pass.TypesInfo.TypeOf(call.Args[value]).Underlying().(*types.Pointer)
+	if !ok {
+		return nil, nil, false
+	}
+	return ptr.Elem(), call.Args[options:], true
+}
+
+// typeArg returns the first type argument of a call to a generic function,
+// or nil if there is none.
+func typeArg(pass *analysis.Pass, call *ast.CallExpr) types.Type {
+	fun := ast.Unparen(call.Fun)
+	switch x := fun.(type) {
+	case *ast.IndexExpr:
+		fun = x.X
+	case *ast.IndexListExpr:
+		fun = x.X
+	}
+	var id *ast.Ident
+	switch x := ast.Unparen(fun).(type) {
+	case *ast.Ident:
+		id = x
+	case *ast.SelectorExpr:
+		id = x.Sel
+	default:
+		return nil
+	}
+	inst, ok := pass.TypesInfo.Instances[id]
+	if !ok || inst.TypeArgs.Len() == 0 {
+		return nil
+	}
+	return inst.TypeArgs.At(0)
+}
+
+// mergetypFunc returns the name of the go-mergetyp function called, if any.
+func mergetypFunc(pass *analysis.Pass, call *ast.CallExpr) string {
+	fn, ok := typeutil.Callee(pass.TypesInfo, call).(*types.Func)
+	if !ok || fn.Pkg() == nil || fn.Pkg().Path() != mergetypPath {
+		return ""
+	}
+	return fn.Name()
+}
+
+func constString(pass *analysis.Pass, e ast.Expr) (string, bool) {
+	tv, ok := pass.TypesInfo.Types[e]
+	if !ok || tv.Value == nil || tv.Value.Kind() != constant.String {
+		return "", false
+	}
+	return constant.StringVal(tv.Value), true
+}
+
+type checker struct {
+	tagKey string
+	sizes  types.Sizes
+}
+
+func (c *checker) check(typ types.Type, path string) error {
+	steps, err := fieldpath.Parse(path)
+	if err != nil {
+		return err
+	}
+	return c.walk(typ, steps)
+}
+
+// walk follows steps through t the same as Gen does.
+func (c *checker) walk(t types.Type, steps []fieldpath.Step) error {
+	for len(steps) > 0 {
+		s := steps[0]
+		switch u := t.Underlying().(type) {
+		case *types.Pointer:
+			t = u.Elem()
+
+		case *types.Array:
+			switch s.Kind {
+			case fieldpath.Field:
+			case fieldpath.Any:
+				steps = steps[1:]
+			case fieldpath.Index:
+				if int64(s.Index) >= u.Len() {
+					return fmt.Errorf("index %v out of range for %v", s, t)
+				}
+				steps = steps[1:]
+			default:
+				return fmt.Errorf("unable to use key selector %v on %v", s, t)
+			}
+			t = u.Elem()
+
+		case *types.Slice:
+			switch s.Kind {
+			case fieldpath.Field:
+			case fieldpath.Any, fieldpath.Index:
+				steps = steps[1:]
+			default:
+				return fmt.Errorf("unable to use key selector %v on %v", s, t)
+			}
+			t = u.Elem()
+
+		case *types.Map:
+			if s.Kind != fieldpath.Key {
+				return fmt.Errorf("unable to use %v on %v, select a key with {key}", s, t)
+			}
+			if err := c.key(u.Key(), s.Name); err != nil {
+				return err
+			}
+			steps = steps[1:]
+			t = u.Elem()
+
+		case *types.Struct:
+			if opaque(t) {
+				return fmt.Errorf("unable to skip or select fields in %v", t)
+			}
+			if s.Kind != fieldpath.Field {
+				return fmt.Errorf("unable to use selector %v on %v", s, t)
+			}
+			ft, err := c.field(u, s.Name)
+			if err != nil {
+				return fmt.Errorf("%v in %v", err, t)
+			}
+			steps = steps[1:]
+			t = ft
+
+		default:
+			return fmt.Errorf("unable to skip or select fields on %v", t)
+		}
+	}
+	return nil
+}
+
+// key checks a {key} literal for map keys of type kt, the same as Gen.
+func (c *checker) key(kt types.Type, lit string) error {
+	k := kind(kt)
+	if !fieldpath.KeyKind(k) {
+		return fmt.Errorf("unable to select map keys of kind %v", k)
+	}
+	if _, ok := fieldpath.ParseKey(lit, k, int(c.sizes.Sizeof(kt))*8); !ok {
+		return fmt.Errorf("invalid key {%s} for map key type %v", lit, kt)
+	}
+	return nil
+}
+
+// kind returns the reflect.Kind of values of type t.
+func kind(t types.Type) reflect.Kind {
+	switch u := t.Underlying().(type) {
+	case *types.Basic:
+		return basicKinds[u.Kind()]
+	case *types.Pointer:
+		return reflect.Ptr
+	case *types.Array:
+		return reflect.Array
+	case *types.Slice:
+		return reflect.Slice
+	case *types.Map:
+		return reflect.Map
+	case *types.Chan:
+		return reflect.Chan
+	case *types.Struct:
+		return reflect.Struct
+	case *types.Signature:
+		return reflect.Func
+	case *types.Interface:
+		return reflect.Interface
+	}
+	return reflect.Invalid
+}
+
+var basicKinds = map[types.BasicKind]reflect.Kind{
+	types.Bool:          reflect.Bool,
+	types.Int:           reflect.Int,
+	types.Int8:          reflect.Int8,
+	types.Int16:         reflect.Int16,
+	types.Int32:         reflect.Int32,
+	types.Int64:         reflect.Int64,
+	types.Uint:          reflect.Uint,
+	types.Uint8:         reflect.Uint8,
+	types.Uint16:        reflect.Uint16,
+	types.Uint32:        reflect.Uint32,
+	types.Uint64:        reflect.Uint64,
+	types.Uintptr:       reflect.Uintptr,
+	types.Float32:       reflect.Float32,
+	types.Float64:       reflect.Float64,
+	types.Complex64:     reflect.Complex64,
+	types.Complex128:    reflect.Complex128,
+	types.String:        reflect.String,
+	types.UnsafePointer: reflect.UnsafePointer,
+}
+
+// opaque returns whether t is a struct that Gen merges as a whole, such that
+// its fields cannot be skipped or selected: times, math/big numbers, and
+// types from sync/atomic.
+func opaque(t types.Type) bool {
+	named, ok := types.Unalias(t).(*types.Named)
+	if !ok || named.Obj().Pkg() == nil {
+		return false
+	}
+	switch named.Obj().Pkg().Path() {
+	case "time":
+		return named.Obj().Name() == "Time"
+	case "math/big":
+		switch named.Obj().Name() {
+		case "Int", "Float", "Rat":
+			return true
+		}
+	case "sync/atomic":
+		return true
+	}
+	return false
+}
+
+// fieldName returns the name a field is addressed by in paths.
+func (c *checker) fieldName(st *types.Struct, i int) string {
+	name := st.Field(i).Name()
+	if c.tagKey == "" {
+		return name
+	}
+	tag := reflect.StructTag(st.Tag(i)).Get(c.tagKey)
+	if idx := strings.IndexByte(tag, ','); idx != -1 {
+		tag = tag[:idx]
+	}
+	if tag == "" || tag == "-" {
+		return name
+	}
+	return tag
+}
+
+// field returns the type of the named field in st, following Go's selector
+// rules for fields promoted from embedded structs.
+func (c *checker) field(st *types.Struct, name string) (types.Type, error) {
+	current := []*types.Struct{st}
+	seen := map[*types.Struct]bool{st: true}
+	for len(current) > 0 {
+		var found []types.Type
+		var next []*types.Struct
+		for _, s := range current {
+			for i := 0; i < s.NumFields(); i++ {
+				f := s.Field(i)
+				if c.fieldName(s, i) == name {
+					found = append(found, f.Type())
+				}
+				if !f.Embedded() {
+					continue
+				}
+				ft := f.Type()
+				if p, ok := ft.Underlying().(*types.Pointer); ok {
+					ft = p.Elem()
+				}
+				if es, ok := ft.Underlying().(*types.Struct); ok && !seen[es] {
+					next = append(next, es)
+				}
+			}
+		}
+		switch len(found) {
+		case 0:
+		case 1:
+			return found[0], nil
+		default:
+			return nil, fmt.Errorf("ambiguous field %s", name)
+		}
+		for _, s := range next {
+			seen[s] = true
+		}
+		current = next
+	}
+	return nil, fmt.Errorf("no field %s", name)
+}
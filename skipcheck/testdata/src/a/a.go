@@ -0,0 +1,91 @@
+package a
+
+import (
+	"math/big"
+	"sync/atomic"
+	"testing"
+	"time"
+
+	"github.com/twmb/go-mergetyp"
+	"github.com/twmb/go-mergetyp/mergetyptest"
+)
+
+type Bar struct {
+	Baz chan int
+}
+
+type foobar struct {
+	bar []Bar
+}
+
+type Embedded struct {
+	Promoted int
+}
+
+type MyType struct {
+	Embedded
+	Foo     foobar
+	p       *int
+	Buckets [4]uint64
+	Labels  map[string]Bar
+	Tagged  int `json:"tagged"`
+}
+
+const skipBaz = "Foo>bar>Baz"
+
+func gens() {
+	mergetyp.Gen(new(MyType), mergetyp.SkipField(skipBaz))
+	mergetyp.Gen(new(MyType), mergetyp.SkipField("Foo>bar>Bax")) // want `invalid SkipField path "Foo>bar>Bax" for a.MyType: no field Bax in a.Bar`
+	mergetyp.MustGen(new(MyType),
+		mergetyp.SkipFields("Promoted", "Embedded>Promoted", `Labels{"x"}>Baz`, "Buckets[3]"),
+		mergetyp.OnlyFields("p", "foo"),                           // want `invalid OnlyFields path "foo" for a.MyType: no field foo in a.MyType`
+		mergetyp.WithStrategy("Buckets[4]", mergetyp.StrategyMax), // want `invalid WithStrategy path "Buckets\[4\]" for a.MyType: index \[4\] out of range for \[4\]uint64`
+		mergetyp.WithStrategy("", mergetyp.StrategyMax),
+		mergetyp.SkipField("Labels>Baz"), // want `invalid SkipField path "Labels>Baz" for a.MyType: unable to use Baz on map\[string\]a.Bar, select a key with \{key\}`
+	)
+	mergetyp.Gen(new(MyType), mergetyp.WithLocking("mu"))                                             // want `invalid WithLocking path "mu" for a.MyType: no field mu in a.MyType`
+	mergetyp.Gen(new(MyType), mergetyp.WithTagNames("json"), mergetyp.SkipFields("tagged", "Tagged")) // want `invalid SkipFields path "Tagged" for a.MyType: no field Tagged in a.MyType`
+
+	var opts []func(*mergetyp.Config) error
+	mergetyp.Gen(new(MyType), opts...)
+}
+
+func others(t *testing.T) {
+	mergetyp.GenClone(new(MyType), mergetyp.SkipField("Buckets[3]"))
+	mergetyp.MustGenClone(new(MyType), mergetyp.SkipField("Bucket"))            // want `invalid SkipField path "Bucket" for a.MyType: no field Bucket in a.MyType`
+	mergetyp.GenDiff(new(MyType), mergetyp.SkipField("Foo>baz"))                // want `invalid SkipField path "Foo>baz" for a.MyType: no field baz in a.foobar`
+	mergetyp.MustGenDiff(new(MyType), mergetyp.OnlyFields("Missing"))           // want `invalid OnlyFields path "Missing" for a.MyType: no field Missing in a.MyType`
+	mergetyp.GenReset(new(MyType), mergetyp.WithLocking("mu"))                  // want `invalid WithLocking path "mu" for a.MyType: no field mu in a.MyType`
+	mergetyp.MustGenReset(new(MyType), mergetyp.SkipFields("Tagged", "tagged")) // want `invalid SkipFields path "tagged" for a.MyType: no field tagged in a.MyType`
+	mergetyp.Plan(new(MyType), mergetyp.SkipField("Labels>Bax"))                // want `invalid SkipField path "Labels>Bax" for a.MyType: unable to use Bax on map\[string\]a.Bar, select a key with \{key\}`
+
+	mergetyp.NewAccumulator[MyType](mergetyp.SkipField("Foo>bar>Baz"))
+	mergetyp.NewAccumulator[MyType](mergetyp.SkipField("Foo>bar>Bax"))               // want `invalid SkipField path "Foo>bar>Bax" for a.MyType: no field Bax in a.Bar`
+	mergetyp.MustNewAccumulator[MyType](mergetyp.SkipField("Nope"))                  // want `invalid SkipField path "Nope" for a.MyType: no field Nope in a.MyType`
+	mergetyp.NewShardedAccumulator[MyType](4, mergetyp.SkipField("Nope"))            // want `invalid SkipField path "Nope" for a.MyType: no field Nope in a.MyType`
+	mergetyp.MustNewShardedAccumulator[MyType](0, mergetyp.OnlyFields("Buckets[9]")) // want `invalid OnlyFields path "Buckets\[9\]" for a.MyType: index \[9\] out of range for \[4\]uint64`
+
+	mergetyptest.AssertPlan(t, new(MyType), "testdata/mytype.plan", mergetyp.SkipField("Embedded>Promoted"))
+	mergetyptest.AssertPlan(t, new(MyType), "testdata/mytype.plan", mergetyp.SkipField("Embedded>Demoted")) // want `invalid SkipField path "Embedded>Demoted" for a.MyType: no field Demoted in a.Embedded`
+}
+
+type Key struct{ N int }
+
+type Special struct {
+	At      time.Time
+	Cents   *big.Int
+	Hits    atomic.Int64
+	Names   map[string]int
+	Small   map[int8]int
+	Structs map[Key]int
+}
+
+func specials() {
+	mergetyp.Gen(new(Special), mergetyp.SkipFields("At", "Cents", "Hits", `Names{"a"}`, "Small{-8}", "Small{0x10}"))
+	mergetyp.Gen(new(Special), mergetyp.SkipField("At>wall"))    // want `invalid SkipField path "At>wall" for a.Special: unable to skip or select fields in time.Time`
+	mergetyp.Gen(new(Special), mergetyp.SkipField("Cents>abs"))  // want `invalid SkipField path "Cents>abs" for a.Special: unable to skip or select fields in math/big.Int`
+	mergetyp.Gen(new(Special), mergetyp.SkipField("Hits>v"))     // want `invalid SkipField path "Hits>v" for a.Special: unable to skip or select fields in sync/atomic.Int64`
+	mergetyp.Gen(new(Special), mergetyp.SkipField("Names{a}"))   // want `invalid SkipField path "Names\{a\}" for a.Special: invalid key \{a\} for map key type string`
+	mergetyp.Gen(new(Special), mergetyp.SkipField("Small{128}")) // want `invalid SkipField path "Small\{128\}" for a.Special: invalid key \{128\} for map key type int8`
+	mergetyp.Gen(new(Special), mergetyp.SkipField("Structs{1}")) // want `invalid SkipField path "Structs\{1\}" for a.Special: unable to select map keys of kind struct`
+}
@@ -0,0 +1,46 @@
+// Package mergetyp is a stand in for go-mergetyp's API for analyzer tests.
+package mergetyp
+
+type Config struct{}
+
+type Strategy uint8
+
+const StrategyMax Strategy = 2
+
+func Gen(interface{}, ...func(*Config) error) (func(l, r interface{}), error) { return nil, nil }
+func MustGen(interface{}, ...func(*Config) error) func(l, r interface{})      { return nil }
+
+func GenClone(interface{}, ...func(*Config) error) (func(dst, src interface{}), error) {
+	return nil, nil
+}
+func MustGenClone(interface{}, ...func(*Config) error) func(dst, src interface{}) { return nil }
+func GenDiff(interface{}, ...func(*Config) error) (func(out, l, r interface{}), error) {
+	return nil, nil
+}
+func MustGenDiff(interface{}, ...func(*Config) error) func(out, l, r interface{}) { return nil }
+func GenReset(interface{}, ...func(*Config) error) (func(v interface{}), error)   { return nil, nil }
+func MustGenReset(interface{}, ...func(*Config) error) func(v interface{})        { return nil }
+
+type PlanNode struct{}
+
+func Plan(interface{}, ...func(*Config) error) (*PlanNode, error) { return nil, nil }
+
+type Accumulator[T any] struct{}
+
+type ShardedAccumulator[T any] struct{}
+
+func NewAccumulator[T any](...func(*Config) error) (*Accumulator[T], error) { return nil, nil }
+func MustNewAccumulator[T any](...func(*Config) error) *Accumulator[T]      { return nil }
+
+func NewShardedAccumulator[T any](int, ...func(*Config) error) (*ShardedAccumulator[T], error) {
+	return nil, nil
+}
+func MustNewShardedAccumulator[T any](int, ...func(*Config) error) *ShardedAccumulator[T] { return nil }
+
+func SkipField(string) func(*Config) error              { return nil }
+func SkipFields(...string) func(*Config) error          { return nil }
+func OnlyFields(...string) func(*Config) error          { return nil }
+func WithStrategy(string, Strategy) func(*Config) error { return nil }
+func WithTagNames(string) func(*Config) error           { return nil }
+func WithLocking(...string) func(*Config) error         { return nil }
+func WithSlowerMapsUnsafely() func(*Config) error       { return nil }
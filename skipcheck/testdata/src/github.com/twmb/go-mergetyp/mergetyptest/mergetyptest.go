@@ -0,0 +1,11 @@
+// Package mergetyptest is a stand in for go-mergetyp/mergetyptest's API for
+// analyzer tests.
+package mergetyptest
+
+import (
+	"testing"
+
+	"github.com/twmb/go-mergetyp"
+)
+
+func AssertPlan(testing.TB, interface{}, string, ...func(*mergetyp.Config) error) {}
@@ -0,0 +1,71 @@
+package mergetyp
+
+import (
+	"fmt"
+	"reflect"
+	"time"
+	"unsafe"
+)
+
+// This file contains the logic to merge time.Time, which is a struct that we
+// must not reach into.
+
+var timeType = reflect.TypeFor[time.Time]()
+
+// timeStrategy returns the name of a strategy for times, which call
+// StrategyMin and StrategyMax earliest and latest.
+func timeStrategy(s Strategy) string {
+	switch s {
+	case StrategyEarliest:
+		return "earliest"
+	case StrategyLatest:
+		return "latest"
+	default:
+		return s.String()
+	}
+}
+
+// genTime generates the closure to merge a time.Time. This returns false if
+// the value is not a time.
+//
+// A zero time is never kept over a non-zero time, regardless of strategy,
+// such that merging into a zero value keeps the first time merged.
+func (g *generator) genTime(v reflect.Value) (mergeF, bool, error) {
+	if v.Type() != timeType {
+		return nil, false, nil
+	}
+	if len(g.rules) > 0 {
+		return nil, true, fmt.Errorf("unable to skip or select fields in %v", timeType)
+	}
+	if g.atomic {
+		return nil, true, notAtomic{g.where(v) + " (" + timeType.String() + ")"}
+	}
+
+	s := g.strategy
+	if s == StrategySum {
+		s = StrategyLatest
+	}
+	g.plan.merged(s)
+
+	var replace func(l, r *time.Time) bool
+	switch s {
+	case StrategyEarliest:
+		replace = func(l, r *time.Time) bool { return r.Before(*l) }
+	case StrategyLatest:
+		replace = func(l, r *time.Time) bool { return r.After(*l) }
+	case StrategyKeep:
+		replace = func(l, r *time.Time) bool { return false }
+	case StrategyOverwrite:
+		replace = func(l, r *time.Time) bool { return true }
+	}
+	return func(l, r unsafe.Pointer) {
+		tl := (*time.Time)(l)
+		tr := (*time.Time)(r)
+		if tr.IsZero() {
+			return
+		}
+		if tl.IsZero() || replace(tl, tr) {
+			*tl = *tr
+		}
+	}, true, nil
+}
@@ -0,0 +1,93 @@
+package mergetyp
+
+import (
+	"strings"
+	"testing"
+	"time"
+)
+
+type timesType struct {
+	First   time.Time
+	Last    time.Time
+	Created time.Time
+	Updated time.Time
+	Owner   int
+	Version int
+}
+
+func TestTimes(t *testing.T) {
+	t0 := time.Date(2020, 1, 1, 0, 0, 0, 0, time.UTC)
+	t1 := t0.Add(time.Hour)
+	t2 := t0.Add(2 * time.Hour)
+
+	opts := []func(*Config) error{
+		WithStrategy("First", StrategyEarliest),
+		WithStrategy("Created", StrategyKeep),
+		WithStrategy("Updated", StrategyOverwrite),
+		WithStrategy("Owner", StrategyKeep),
+		WithStrategy("Version", StrategyOverwrite),
+	}
+	f := MustGen(new(timesType), opts...)
+
+	var l timesType
+	for _, r := range []timesType{
+		{First: t1, Last: t1, Created: t1, Updated: t1, Owner: 1, Version: 1},
+		{First: t0, Last: t0, Created: t0, Updated: t0, Owner: 2, Version: 2},
+		{First: t2, Last: t2, Created: t2, Updated: t2, Owner: 3, Version: 3},
+		{}, // zero times and numbers never replace non-zero ones
+	} {
+		f(&l, &r)
+	}
+	exp := timesType{First: t0, Last: t2, Created: t1, Updated: t2, Owner: 1, Version: 3}
+	if l != exp {
+		t.Errorf("got %+v != exp %+v", l, exp)
+	}
+
+	// Times are not reached into, so their locations are kept intact.
+	loc := time.FixedZone("test", 3600)
+	l = timesType{}
+	r := timesType{Last: t1.In(loc)}
+	f(&l, &r)
+	if l.Last.Location() != loc || !l.Last.Equal(t1) {
+		t.Errorf("got %v, exp %v", l.Last, r.Last)
+	}
+
+	var c timesType
+	MustGenClone(new(timesType))(&c, &l)
+	if c != l {
+		t.Errorf("clone got %+v != exp %+v", c, l)
+	}
+	MustGenReset(new(timesType), opts...)(&c)
+	if c != (timesType{}) {
+		t.Errorf("reset got %+v, exp zero", c)
+	}
+
+	// Times cannot be subtracted, and are copied from the left.
+	var d timesType
+	cur := timesType{First: t0, Last: t1, Created: t0, Updated: t1, Owner: 1, Version: 3}
+	MustGenDiff(new(timesType), opts...)(&d, &cur, &timesType{Last: t2, Owner: 5})
+	if d != cur {
+		t.Errorf("diff got %+v != exp %+v", d, cur)
+	}
+
+	plan, err := Plan(new(timesType), opts...)
+	if err != nil {
+		t.Fatal(err)
+	}
+	if s := plan.String(); !strings.Contains(s, "First time.Time: merge earliest") ||
+		!strings.Contains(s, "Last time.Time: merge latest") {
+		t.Errorf("plan does not name time strategies:\n%s", s)
+	}
+
+	for _, test := range []struct {
+		name string
+		gen  func() error
+	}{
+		{"atomic", func() error { _, err := Gen(new(timesType), WithAtomicMerges()); return err }},
+		{"select", func() error { _, err := Gen(new(timesType), SkipField("Last>wall")); return err }},
+	} {
+		if err := test.gen(); err == nil {
+			t.Errorf("%s: expected error", test.name)
+		}
+	}
+}
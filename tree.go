@@ -0,0 +1,276 @@
+package mergetyp
+
+import (
+	"fmt"
+	"reflect"
+	"unsafe"
+)
+
+// This file contains the logic to merge dynamic trees, such as what
+// encoding/json decodes into; see WithTrees.
+
+var (
+	anyType      = reflect.TypeFor[any]()
+	mapAnyType   = reflect.TypeFor[map[string]any]()
+	sliceAnyType = reflect.TypeFor[[]any]()
+)
+
+// treeConfig is what WithTrees configures.
+type treeConfig struct {
+	strings  Strategy
+	mismatch MismatchPolicy
+}
+
+// tree merges the values within a dynamic tree.
+type tree struct {
+	strategy Strategy
+	strings  Strategy
+	mismatch MismatchPolicy
+
+	// other merges dynamic types that trees do not have special
+	// support for, such as integers from trees built by hand.
+	other *dispatcher
+}
+
+// genTree generates the closure to merge a dynamic tree. This returns false
+// if the value is not a tree.
+func (g *generator) genTree(v reflect.Value) (mergeF, bool, error) {
+	t := v.Type()
+	if g.trees == nil || t != anyType && t != mapAnyType && t != sliceAnyType {
+		return nil, false, nil
+	}
+	if len(g.rules) > 0 {
+		return nil, true, fmt.Errorf("unable to skip or select fields in %v", t)
+	}
+	if g.atomic {
+		return nil, true, notAtomic{g.where(v) + " (" + t.String() + ")"}
+	}
+	g.plan.dynamic()
+
+	other, err := g.dispatcher(anyType, g.trees.mismatch)
+	if err != nil {
+		return nil, true, err
+	}
+	tr := &tree{
+		strategy: g.strategy,
+		strings:  g.trees.strings,
+		mismatch: g.trees.mismatch,
+		other:    other,
+	}
+	switch t {
+	case mapAnyType:
+		return func(l, r unsafe.Pointer) {
+			pl := (*map[string]any)(l)
+			*pl = tr.mergeMap(*pl, *(*map[string]any)(r))
+		}, true, nil
+	case sliceAnyType:
+		return func(l, r unsafe.Pointer) {
+			pl := (*[]any)(l)
+			*pl = tr.mergeSlice(*pl, *(*[]any)(r))
+		}, true, nil
+	default:
+		return func(l, r unsafe.Pointer) {
+			pl := (*any)(l)
+			*pl = tr.merge(*pl, *(*any)(r))
+		}, true, nil
+	}
+}
+
+// merge returns the merge of two values in a tree.
+func (tr *tree) merge(l, r any) any {
+	if l == nil {
+		return r
+	}
+	if r == nil {
+		return l
+	}
+	if reflect.TypeOf(l) != reflect.TypeOf(r) {
+		switch tr.mismatch {
+		case MismatchTakeRight:
+			return r
+		case MismatchPanic:
+			panic(fmt.Sprintf("mergetyp: unable to merge %T with %T in tree", l, r))
+		}
+		return l
+	}
+
+	switch rv := r.(type) {
+	case map[string]any:
+		return tr.mergeMap(l.(map[string]any), rv)
+	case []any:
+		return tr.mergeSlice(l.([]any), rv)
+	case float64:
+		return mergeOrdered(tr.strategy, l.(float64), rv)
+	case string:
+		return mergeOrdered(tr.strings, l.(string), rv)
+	case bool:
+		if tr.strategy == StrategyMin {
+			return l.(bool) && rv
+		}
+		return l.(bool) || rv
+	default:
+		tr.other.merge(unsafe.Pointer(&l), unsafe.Pointer(&r))
+		return l
+	}
+}
+
+// mergeMap merges r into l, adopting values of keys that only r has. As with
+// other maps, r should not be used after merging.
+func (tr *tree) mergeMap(l, r map[string]any) map[string]any {
+	if l == nil {
+		return r
+	}
+	for k, rv := range r {
+		if lv, exists := l[k]; exists {
+			l[k] = tr.merge(lv, rv)
+		} else {
+			l[k] = rv
+		}
+	}
+	return l
+}
+
+// mergeSlice merges two slices the same as typed slices: elements that both
+// have are merged, and the tail of a longer right slice is adopted.
+func (tr *tree) mergeSlice(l, r []any) []any {
+	n := min(len(l), len(r))
+	for i := 0; i < n; i++ {
+		l[i] = tr.merge(l[i], r[i])
+	}
+	if len(r) > len(l) {
+		copy(r, l)
+		l = r
+	}
+	return l
+}
+
+// mergeOrdered returns the merge of two values with a strategy.
+func mergeOrdered[T float64 | string](s Strategy, l, r T) T {
+	if s == StrategySum {
+		return l + r
+	}
+	if replaces(s, l, r) {
+		return r
+	}
+	return l
+}
+
+// resetTree generates the closure to reset a dynamic tree. This returns false
+// if the value is not a tree.
+//
+// Maps are cleared and slices truncated, because everything within them is
+// merged. Numbers, bools, and strings held in an any are reset to what
+// merging with their strategy starts from, and other types are reset as with
+// WithInterfaceMerges.
+func (g *generator) resetTree(t reflect.Type) (resetF, bool, error) {
+	if g.trees == nil || t != anyType && t != mapAnyType && t != sliceAnyType {
+		return nil, false, nil
+	}
+	if len(g.rules) > 0 {
+		return nil, true, fmt.Errorf("unable to skip or select fields in %v", t)
+	}
+	switch t {
+	case mapAnyType:
+		return func(p unsafe.Pointer) { clear(*(*map[string]any)(p)) }, true, nil
+	case sliceAnyType:
+		return func(p unsafe.Pointer) { (*sliceHeader)(p).len = 0 }, true, nil
+	}
+
+	other, err := g.resetInterface(anyType)
+	if err != nil {
+		return nil, true, err
+	}
+	num, _ := identity(reflect.TypeFor[float64](), g.strategy)
+	zero := num.Interface()
+	min := g.strategy == StrategyMin
+	return func(p unsafe.Pointer) {
+		pv := (*any)(p)
+		switch v := (*pv).(type) {
+		case nil:
+		case map[string]any:
+			clear(v)
+		case []any:
+			*pv = v[:0]
+		case float64:
+			*pv = zero
+		case string:
+			*pv = ""
+		case bool:
+			*pv = min
+		default:
+			other(p)
+		}
+	}, true, nil
+}
+
+// cloneTree generates the closure to deep copy a dynamic tree. This returns
+// false if the value is not a tree.
+func (g *generator) cloneTree(t reflect.Type) (mergeF, bool, error) {
+	if g.trees == nil || t != anyType && t != mapAnyType && t != sliceAnyType {
+		return nil, false, nil
+	}
+	if len(g.rules) > 0 {
+		return nil, true, fmt.Errorf("unable to skip or select fields in %v", t)
+	}
+	other, err := g.cloneInterface(anyType)
+	if err != nil {
+		return nil, true, err
+	}
+	tc := &treeCloner{other}
+	switch t {
+	case mapAnyType:
+		return func(d, s unsafe.Pointer) {
+			*(*map[string]any)(d) = tc.cloneMap(*(*map[string]any)(s))
+		}, true, nil
+	case sliceAnyType:
+		return func(d, s unsafe.Pointer) {
+			*(*[]any)(d) = tc.cloneSlice(*(*[]any)(s))
+		}, true, nil
+	default:
+		return func(d, s unsafe.Pointer) {
+			*(*any)(d) = tc.clone(*(*any)(s))
+		}, true, nil
+	}
+}
+
+// treeCloner deep copies the values within a dynamic tree.
+type treeCloner struct {
+	other mergeF // clones types that trees have no special support for
+}
+
+func (tc *treeCloner) clone(v any) any {
+	switch v := v.(type) {
+	case nil, float64, string, bool:
+		return v
+	case map[string]any:
+		return tc.cloneMap(v)
+	case []any:
+		return tc.cloneSlice(v)
+	default:
+		var d any
+		tc.other(unsafe.Pointer(&d), unsafe.Pointer(&v))
+		return d
+	}
+}
+
+func (tc *treeCloner) cloneMap(m map[string]any) map[string]any {
+	if m == nil {
+		return nil
+	}
+	c := make(map[string]any, len(m))
+	for k, v := range m {
+		c[k] = tc.clone(v)
+	}
+	return c
+}
+
+func (tc *treeCloner) cloneSlice(s []any) []any {
+	if s == nil {
+		return nil
+	}
+	c := make([]any, len(s))
+	for i, v := range s {
+		c[i] = tc.clone(v)
+	}
+	return c
+}
@@ -0,0 +1,119 @@
+package mergetyp
+
+import (
+	"encoding/json"
+	"reflect"
+	"testing"
+)
+
+func decodeTree(t *testing.T, s string) map[string]any {
+	t.Helper()
+	var m map[string]any
+	if err := json.Unmarshal([]byte(s), &m); err != nil {
+		t.Fatal(err)
+	}
+	return m
+}
+
+type treeType struct {
+	Count int
+	Attrs map[string]any
+	Peak  any
+	Hand  []any
+}
+
+func TestWithTrees(t *testing.T) {
+	l := decodeTree(t, `{"hits": 1, "name": "a", "up": false, "nested": {"n": 1}, "list": [1, 2], "mixed": 1}`)
+	r := decodeTree(t, `{"hits": 2, "name": "b", "up": true, "nested": {"n": 2, "m": 3}, "list": [1, 1, 1], "mixed": "x", "new": null}`)
+	f := MustGen(new(map[string]any), WithTrees(StrategyKeep, MismatchKeepLeft))
+	f(&l, &r)
+	exp := decodeTree(t, `{"hits": 3, "name": "a", "up": true, "nested": {"n": 3, "m": 3}, "list": [2, 3, 1], "mixed": 1, "new": null}`)
+	if !reflect.DeepEqual(l, exp) {
+		t.Errorf("got %v != exp %v", l, exp)
+	}
+
+	// A longer right slice does not change which side is left.
+	l = map[string]any{"s": []any{"a"}}
+	r = map[string]any{"s": []any{1.0, "z"}}
+	f(&l, &r)
+	if exp := []any{"a", "z"}; !reflect.DeepEqual(l["s"], exp) {
+		t.Errorf("got %v != exp %v", l["s"], exp)
+	}
+
+	// Trees can be cloned and reset, such as for accumulators.
+	var c map[string]any
+	l = decodeTree(t, `{"nested": {"n": 3}}`)
+	MustGenClone(new(map[string]any), WithTrees(StrategyKeep, MismatchKeepLeft))(&c, &l)
+	c["nested"].(map[string]any)["n"] = 0.0
+	if l["nested"].(map[string]any)["n"] != 3.0 {
+		t.Error("clone shares a nested map")
+	}
+	acc := MustNewAccumulator[treeType](WithTrees(StrategyKeep, MismatchKeepLeft))
+	acc.Add(&treeType{Count: 1, Attrs: decodeTree(t, `{"a": 1}`), Peak: 2.0})
+	acc.Add(&treeType{Count: 1, Attrs: decodeTree(t, `{"a": 2, "b": "x"}`), Peak: 3.0})
+	got := acc.Swap()
+	if exp := (treeType{Count: 2, Attrs: decodeTree(t, `{"a": 3, "b": "x"}`), Peak: 5.0}); !reflect.DeepEqual(*got, exp) {
+		t.Errorf("accumulated %+v != exp %+v", *got, exp)
+	}
+	acc.Add(&treeType{Count: 1, Peak: 1.0})
+	if got := acc.Swap(); got.Count != 1 || len(got.Attrs) != 0 || got.Peak != 1.0 {
+		t.Errorf("accumulated after swap %+v, exp a count of 1, no attrs, and a peak of 1", *got)
+	}
+
+	// Trees within structs follow the strategies of their fields, and
+	// other dynamic types are merged as with interface merges.
+	f = MustGen(new(treeType),
+		WithTrees(StrategyOverwrite, MismatchTakeRight),
+		WithStrategy("Peak", StrategyMax),
+	)
+	tl := treeType{Count: 1, Attrs: decodeTree(t, `{"name": "a", "v": 1}`), Peak: 5.0, Hand: []any{int64(1), "a"}}
+	tr := treeType{Count: 1, Attrs: decodeTree(t, `{"name": "b", "v": "x"}`), Peak: 3.0, Hand: []any{int64(2), 1.0}}
+	f(&tl, &tr)
+	texp := treeType{Count: 2, Attrs: decodeTree(t, `{"name": "b", "v": "x"}`), Peak: 5.0, Hand: []any{int64(3), 1.0}}
+	if !reflect.DeepEqual(tl, texp) {
+		t.Errorf("got %+v != exp %+v", tl, texp)
+	}
+
+	f = MustGen(new(map[string]any), WithTrees(StrategyKeep, MismatchPanic))
+	func() {
+		defer func() {
+			if recover() == nil {
+				t.Error("expected panic on mismatch")
+			}
+		}()
+		l, r := decodeTree(t, `{"a": 1}`), decodeTree(t, `{"a": "1"}`)
+		f(&l, &r)
+	}()
+
+	for _, opts := range [][]func(*Config) error{
+		{WithTrees(StrategySum, MismatchKeepLeft)},
+		{WithTrees(StrategyKeep, MismatchPolicy(100))},
+		{WithTrees(StrategyKeep, MismatchKeepLeft), SkipField(`Attrs{"name"}`)},
+		{WithTrees(StrategyKeep, MismatchKeepLeft), WithAtomicMerges(), SkipField("Count")},
+	} {
+		if _, err := Gen(new(treeType), opts...); err == nil {
+			t.Error("expected error")
+		}
+	}
+}
+
+func TestWithTreesInterfaceImpls(t *testing.T) {
+	// Other types in trees use the types registered for any.
+	f := MustGen(new(any),
+		WithTrees(StrategyKeep, MismatchKeepLeft),
+		WithInterfaceImpls(reflect.TypeFor[any](), UnknownPanic, reflect.TypeFor[clickPayload]()),
+	)
+	var l, r any = map[string]any{"c": clickPayload{1, 1}}, map[string]any{"c": clickPayload{1, 2}}
+	f(&l, &r)
+	if got := l.(map[string]any)["c"]; got != (clickPayload{2, 3}) {
+		t.Errorf("got %+v != exp {2 3}", got)
+	}
+
+	defer func() {
+		if recover() == nil {
+			t.Error("expected panic for unregistered type")
+		}
+	}()
+	l, r = map[string]any{"i": 1}, map[string]any{"i": 2}
+	f(&l, &r)
+}